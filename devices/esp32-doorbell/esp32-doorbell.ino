@@ -57,6 +57,15 @@ int relayPins[] = {
 	PHYSICAL_PIN_33,
 };
 
+// Sensors on spare pins: name, pin, kind, unit, scale, offset.
+SensorDef sensors[] = {
+	// {"hall_temp", PHYSICAL_PIN_5, SENSOR_ANALOG, "C", 0.1, 0}, // LM35 on ADC6 (10 mV/C)
+	// {"front_door", PHYSICAL_PIN_7, SENSOR_DIGITAL, "", 1, 0},	// reed contact to GND
+	// {"hall_motion", PHYSICAL_PIN_8, SENSOR_DIGITAL, "", 1, 0},	// PIR output (open collector)
+};
+const int SENSOR_COUNT = sizeof(sensors) / sizeof(sensors[0]);
+SensorState sensorStates[SENSOR_COUNT];
+
 const int PIN_LED_ONBOARD = 2;
 const int PIN_DOORBELL_RING = PHYSICAL_PIN_31; // active LOW input
 
@@ -97,6 +106,9 @@ void monitorDoorRing() {
 		if (current == LOW && lastDoorbellState == HIGH) {
 			Serial.println("*************** Door is ringing");
 			telnetPrintln("*************** Door is ringing");
+			// machine-readable event for the server
+			Serial.println("RING");
+			telnetPrintln("RING");
 			delay(2000);
 			buzzDoor();
 		}
@@ -105,10 +117,19 @@ void monitorDoorRing() {
 	}
 }
 
+void handleCommand(const char *cmd, const char *source) {
+	if (strcmp(cmd, "REBOOT") == 0) {
+		rebootBoard(source);
+	}
+}
+
 void handleTelnet() {
 	if (acceptTelnetClient()) {
 		telnetPrintln("Connected to ESP32 Telnet console.");
 		telnetPrintln("Press Ctrl-C or Ctrl-D to disconnect.");
+		sendBootTelnet();
+		sendTelemetryTelnet();
+		reportAllSensors(sensorStates, SENSOR_COUNT);
 	}
 
 	if (!(telnetClient && telnetClient.connected()))
@@ -128,6 +149,12 @@ void handleTelnet() {
 			break;
 		}
 
+		if (commandPending(telnetCmd, c)) {
+			if (feedCommandChar(telnetCmd, c))
+				handleCommand(telnetCmd.buf, "TELNET");
+			continue;
+		}
+
 		if (c == '\r' || c == '\n')
 			continue;
 
@@ -143,6 +170,7 @@ void setup() {
 	Serial.begin(115200);
 	delay(1000);
 	Serial.println();
+	sendBootSerial();
 	Serial.println("Connecting to WiFi...");
 
 	WiFi.mode(WIFI_STA);
@@ -176,6 +204,8 @@ void setup() {
 
 	pinMode(PIN_DOORBELL_RING, INPUT);
 
+	setupSensors(sensors, SENSOR_COUNT);
+
 	initBlink(PIN_LED_ONBOARD);
 }
 
@@ -184,10 +214,14 @@ void loop() {
 	handleTelnet();
 
 	monitorDoorRing();
+	pollSensors(sensors, sensorStates, SENSOR_COUNT);
 
 	if (Serial.available() > 0) {
 		int inByte = Serial.read();
-		if (inByte == '1') {
+		if (commandPending(serialCmd, (char) inByte)) {
+			if (feedCommandChar(serialCmd, (char) inByte))
+				handleCommand(serialCmd.buf, "SERIAL");
+		} else if (inByte == '1') {
 			Serial.print("[SERIAL] buzzing door\n");
 			buzzDoor();
 			Serial.print("[SERIAL] buzzed door\n");
@@ -204,5 +238,11 @@ void loop() {
 		hbSeq++;
 	}
 
+	if (now - telemLast >= TELEMETRY_INTERVAL_MS) {
+		telemLast = now;
+		sendTelemetrySerial();
+		sendTelemetryTelnet();
+	}
+
 	updateBlink(connected, PIN_LED_ONBOARD);
 }
@@ -5,3 +5,6 @@
 
 #define OTA_HOSTNAME "esp32-1"
 #define OTA_PASSWORD "PASSWORD_HERE"
+
+// Relays 1-8 in order; expands after the PHYSICAL_PIN_* table in the sketch.
+#define RELAY_PIN_MAP PHYSICAL_PIN_35, PHYSICAL_PIN_34, PHYSICAL_PIN_33, PHYSICAL_PIN_31, PHYSICAL_PIN_30, PHYSICAL_PIN_29, PHYSICAL_PIN_28, PHYSICAL_PIN_27
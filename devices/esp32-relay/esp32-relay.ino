@@ -58,16 +58,17 @@ enum RELAY_INDEXES {
 	RELAY8
 };
 
-int relayPins[] = {
-	PHYSICAL_PIN_35, // Relays 1-8 will be connected to physical pins 35, 34, 33, 31, 30, 29, 28, 27
-	PHYSICAL_PIN_34,
-	PHYSICAL_PIN_33,
-	PHYSICAL_PIN_31,
-	PHYSICAL_PIN_30,
-	PHYSICAL_PIN_29,
-	PHYSICAL_PIN_28,
-	PHYSICAL_PIN_27,
+// Relays 1-8 pin map comes from config.h (hektor firmware-config relays)
+int relayPins[] = {RELAY_PIN_MAP};
+
+// Sensors on spare pins: name, pin, kind, unit, scale, offset.
+SensorDef sensors[] = {
+	// {"hall_temp", PHYSICAL_PIN_5, SENSOR_ANALOG, "C", 0.1, 0}, // LM35 on ADC6 (10 mV/C)
+	// {"front_door", PHYSICAL_PIN_7, SENSOR_DIGITAL, "", 1, 0},	// reed contact to GND
+	// {"hall_motion", PHYSICAL_PIN_8, SENSOR_DIGITAL, "", 1, 0},	// PIR output (open collector)
 };
+const int SENSOR_COUNT = sizeof(sensors) / sizeof(sensors[0]);
+SensorState sensorStates[SENSOR_COUNT];
 
 const int PIN_LED_ONBOARD = 2; // ??????
 const int PIN_LED = PHYSICAL_PIN_26;
@@ -104,10 +105,45 @@ void reportRelayStatesTelnet() {
     }
 }
 
+// setRelays applies "SET:<mask>:<which>" (hex bytes): relays whose bit is set
+// in which are switched to their bit in mask, then the new states are reported
+// once.
+void setRelays(const char *arg, const char *source) {
+	char *end;
+	unsigned long mask = strtoul(arg, &end, 16);
+	if (*end != ':')
+		return;
+	unsigned long which = strtoul(end + 1, &end, 16);
+	if (*end != '\0')
+		return;
+	for (int i = 0; i < 8; i++) {
+		if (which & (1 << i))
+			digitalWrite(relayPins[i], (mask & (1 << i)) ? HIGH : LOW);
+	}
+	delay(20);
+	if (strcmp(source, "TELNET") == 0)
+		reportRelayStatesTelnet();
+	else
+		reportRelayStatesSerial();
+	Serial.print("[");
+	Serial.print(source);
+	Serial.print("] Set relays ");
+	Serial.println(arg);
+}
+
+void handleCommand(const char *cmd, const char *source) {
+	if (strcmp(cmd, "REBOOT") == 0) {
+		rebootBoard(source);
+	} else if (strncmp(cmd, "SET:", 4) == 0) {
+		setRelays(cmd + 4, source);
+	}
+}
+
 void setup() {
 	Serial.begin(115200);
 	delay(1000);
 	Serial.println();
+	sendBootSerial();
 	Serial.println("Connecting to WiFi...");
 
 	WiFi.mode(WIFI_STA);
@@ -136,6 +172,8 @@ void setup() {
 		digitalWrite(relayPins[i], LOW);
 	}
 
+	setupSensors(sensors, SENSOR_COUNT);
+
 	pinMode(PIN_LED, OUTPUT);
 	pinMode(PIN_LED_ONBOARD, OUTPUT);
 	digitalWrite(PIN_LED, LOW);
@@ -153,7 +191,10 @@ void loop() {
 	if (acceptTelnetClient()) {
 		telnetClient.println("Connected to ESP32 Telnet console.");
 		telnetClient.println("Press Ctrl-C or Ctrl-D to disconnect.");
+		sendBootTelnet();
+		sendTelemetryTelnet();
 		reportRelayStatesTelnet();
+		reportAllSensors(sensorStates, SENSOR_COUNT);
 	}
 
 	if (telnetClient && telnetClient.connected() && telnetClient.available()) {
@@ -171,6 +212,12 @@ void loop() {
 				break;
 			}
 
+			if (commandPending(telnetCmd, c)) {
+				if (feedCommandChar(telnetCmd, c))
+					handleCommand(telnetCmd.buf, "TELNET");
+				continue;
+			}
+
 			if (c == '\r' || c == '\n')
 				continue;
 
@@ -187,7 +234,10 @@ void loop() {
 
 	if (Serial.available() > 0) {
 		int inByte = Serial.read();
-		if (inByte >= '1' && inByte <= '8') {
+		if (commandPending(serialCmd, (char) inByte)) {
+			if (feedCommandChar(serialCmd, (char) inByte))
+				handleCommand(serialCmd.buf, "SERIAL");
+		} else if (inByte >= '1' && inByte <= '8') {
 			int relIndex = inByte - '1';
 			toggleRelayAtIndex(relIndex);
 			delay(20);
@@ -207,5 +257,13 @@ void loop() {
 		sendHeartbeatTelnet();
 	}
 
+	if (now - telemLast >= TELEMETRY_INTERVAL_MS) {
+		telemLast = now;
+		sendTelemetrySerial();
+		sendTelemetryTelnet();
+	}
+
+	pollSensors(sensors, sensorStates, SENSOR_COUNT);
+
 	updateBlink(connected, PIN_LED_ONBOARD);
 }
@@ -1,6 +1,7 @@
 #pragma once
 #include <WiFi.h>
 #include <ArduinoOTA.h>
+#include <esp_system.h>
 
 static WiFiServer telnetServer(23);
 static WiFiClient telnetClient;
@@ -9,6 +10,9 @@ static const unsigned long HEARTBEAT_INTERVAL_MS = 1000;
 static unsigned long hbLast = 0;
 static uint8_t hbSeq = 0;
 
+static const unsigned long TELEMETRY_INTERVAL_MS = 30000;
+static unsigned long telemLast = 0;
+
 static const unsigned long SHORT_BLINK = 100;
 static const unsigned long LONG_SILENCE = 1000;
 static const unsigned long DISCONNECTED_BLINK = 500;
@@ -61,6 +65,186 @@ static inline void sendHeartbeatTelnet() {
 	}
 }
 
+static inline void formatTelemetry(char *buf, size_t len) {
+	snprintf(buf, len, "TELEM:rssi=%d uptime=%lu heap=%u\n",
+		(int) WiFi.RSSI(), millis() / 1000, (unsigned) ESP.getFreeHeap());
+}
+
+static inline void sendTelemetrySerial() {
+	char buf[64];
+	formatTelemetry(buf, sizeof(buf));
+	Serial.print(buf);
+}
+
+static inline void sendTelemetryTelnet() {
+	if (telnetClient && telnetClient.connected()) {
+		char buf[64];
+		formatTelemetry(buf, sizeof(buf));
+		telnetClient.print(buf);
+	}
+}
+
+// Sensor inputs on spare pins, reported as "SENSOR:<name>=<value>[ <unit>]".
+// Digital inputs report on change, analog and touch inputs periodically.
+enum SensorKind {
+	SENSOR_DIGITAL, // contact/motion, INPUT_PULLUP; 1 = active (pulled LOW)
+	SENSOR_ANALOG,	// ADC millivolts
+	SENSOR_TOUCH,	// raw touchRead() value
+};
+
+struct SensorDef {
+	const char *name;
+	int pin;
+	SensorKind kind;
+	const char *unit;
+	float scale; // reported value = raw * scale + offset
+	float offset;
+};
+
+struct SensorState {
+	float last;
+	unsigned long lastReport;
+	bool reported;
+};
+
+static const unsigned long SENSOR_POLL_MS = 100;
+static const unsigned long SENSOR_REPORT_MS = 10000;
+static unsigned long sensorPollLast = 0;
+
+static inline void setupSensors(const SensorDef *defs, int count) {
+	for (int i = 0; i < count; i++) {
+		if (defs[i].kind == SENSOR_DIGITAL)
+			pinMode(defs[i].pin, INPUT_PULLUP);
+		else if (defs[i].kind == SENSOR_ANALOG)
+			pinMode(defs[i].pin, INPUT);
+	}
+}
+
+static inline float readSensorRaw(const SensorDef &def) {
+	switch (def.kind) {
+	case SENSOR_DIGITAL:
+		return digitalRead(def.pin) == LOW ? 1 : 0;
+	case SENSOR_ANALOG:
+		return analogReadMilliVolts(def.pin);
+	case SENSOR_TOUCH:
+		return touchRead(def.pin);
+	}
+	return 0;
+}
+
+static inline void sendSensorReading(const SensorDef &def, float value) {
+	char buf[64];
+	if (def.unit && def.unit[0])
+		snprintf(buf, sizeof(buf), "SENSOR:%s=%.2f %s\n", def.name, value, def.unit);
+	else
+		snprintf(buf, sizeof(buf), "SENSOR:%s=%.2f\n", def.name, value);
+	Serial.print(buf);
+	if (telnetClient && telnetClient.connected()) {
+		telnetClient.print(buf);
+	}
+}
+
+// reportAllSensors forces a report of every sensor, e.g. for a fresh telnet client.
+static inline void reportAllSensors(SensorState *states, int count) {
+	for (int i = 0; i < count; i++) {
+		states[i].reported = false;
+	}
+}
+
+static inline void pollSensors(const SensorDef *defs, SensorState *states, int count) {
+	unsigned long now = millis();
+	if (now - sensorPollLast < SENSOR_POLL_MS)
+		return;
+	sensorPollLast = now;
+
+	for (int i = 0; i < count; i++) {
+		float value = readSensorRaw(defs[i]) * defs[i].scale + defs[i].offset;
+		bool changed = defs[i].kind == SENSOR_DIGITAL && value != states[i].last;
+		bool due = now - states[i].lastReport >= SENSOR_REPORT_MS;
+		if (!states[i].reported || changed || due) {
+			sendSensorReading(defs[i], value);
+			states[i].last = value;
+			states[i].lastReport = now;
+			states[i].reported = true;
+		}
+	}
+}
+
+// Text commands are lines starting with an uppercase letter (e.g. "REBOOT\n").
+// Single-character commands (relay digits) are handled by the sketch as before.
+struct CommandBuffer {
+	char buf[32];
+	uint8_t len;
+};
+
+static CommandBuffer telnetCmd = {{0}, 0};
+static CommandBuffer serialCmd = {{0}, 0};
+
+static inline bool commandPending(const CommandBuffer &cb, char c) {
+	return cb.len > 0 || (c >= 'A' && c <= 'Z');
+}
+
+// feedCommandChar appends c to the buffer and returns true once a full line is
+// available in cb.buf.
+static inline bool feedCommandChar(CommandBuffer &cb, char c) {
+	if (c == '\r' || c == '\n') {
+		if (cb.len == 0)
+			return false;
+		cb.buf[cb.len] = '\0';
+		cb.len = 0;
+		return true;
+	}
+	if (cb.len < sizeof(cb.buf) - 1)
+		cb.buf[cb.len++] = c;
+	return false;
+}
+
+static inline const char *resetReasonString() {
+	switch (esp_reset_reason()) {
+	case ESP_RST_POWERON:
+		return "poweron";
+	case ESP_RST_EXT:
+		return "external";
+	case ESP_RST_SW:
+		return "software";
+	case ESP_RST_PANIC:
+		return "panic";
+	case ESP_RST_INT_WDT:
+	case ESP_RST_TASK_WDT:
+	case ESP_RST_WDT:
+		return "watchdog";
+	case ESP_RST_DEEPSLEEP:
+		return "deepsleep";
+	case ESP_RST_BROWNOUT:
+		return "brownout";
+	default:
+		return "unknown";
+	}
+}
+
+static inline void sendBootSerial() {
+	Serial.print(String("BOOT:") + resetReasonString() + "\n");
+}
+
+static inline void sendBootTelnet() {
+	if (telnetClient && telnetClient.connected()) {
+		telnetClient.print(String("BOOT:") + resetReasonString() + "\n");
+	}
+}
+
+static inline void rebootBoard(const char *source) {
+	Serial.print("[");
+	Serial.print(source);
+	Serial.println("] Reboot requested");
+	if (telnetClient && telnetClient.connected()) {
+		telnetClient.println("Rebooting...");
+		telnetClient.flush();
+		telnetClient.stop();
+	}
+	delay(100);
+	ESP.restart();
+}
+
 static inline void setupOTA(const char *hostname, const char *password) {
 	ArduinoOTA.setHostname(hostname);
 	ArduinoOTA.setPassword(password);
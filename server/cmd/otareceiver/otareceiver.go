@@ -0,0 +1,45 @@
+// Command otareceiver runs a local stand-in for a board's ArduinoOTA endpoint.
+// Point a device record at it (PUT /devices/{name}/ota) to try uploads without
+// touching real hardware.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"relaypanel/internal/logging"
+	"relaypanel/internal/ota"
+)
+
+func main() {
+	logging.Setup()
+
+	addrFlag := flag.String("addr", fmt.Sprintf("127.0.0.1:%d", ota.DefaultPort), "UDP listen address")
+	passwordFlag := flag.String("password", "", "OTA password (empty disables auth)")
+	outFlag := flag.String("out", "", "write received images to this file")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rcv := &ota.Receiver{
+		Addr:     *addrFlag,
+		Password: *passwordFlag,
+		OnImage: func(image []byte) {
+			if *outFlag == "" {
+				return
+			}
+			if err := os.WriteFile(*outFlag, image, 0o644); err != nil {
+				slog.Error("failed to write image", "path", *outFlag, "err", err)
+			}
+		},
+	}
+	if err := rcv.ListenAndServe(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/fwconfig"
+)
+
+// runFirmwareConfig renders config.h for a device from its database record:
+//
+//	server firmware-config [flags] <device>
+func runFirmwareConfig(args []string) error {
+	fs := flag.NewFlagSet("firmware-config", flag.ContinueOnError)
+	outFlag := fs.String("o", "", "write config.h to this path instead of stdout")
+	ssidFlag := fs.String("wifi-ssid", "", "WiFi SSID (remembered for later runs)")
+	wifiPassFlag := fs.String("wifi-password", "", "WiFi password (remembered for later runs)")
+	hostnameFlag := fs.String("hostname", "", "set the board's mDNS hostname (server dials <hostname>.local)")
+	pinsFlag := fs.String("pins", "", "comma-separated physical pins for relays 1..8")
+	keepFlag := fs.Bool("keep-password", false, "reuse the current OTA password instead of generating a new one")
+	forceFlag := fs.Bool("force", false, "rotate even though the last rotated password hasn't been confirmed; the board must be running it")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s firmware-config [flags] <device>\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Renders config.h (and the relay pin map) from the device records and")
+		fmt.Fprintln(os.Stderr, "rotates the board's OTA password unless --keep-password is given.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("missing device name")
+	}
+	name := fs.Arg(0)
+	// allow flags after the device name too
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db.Connect(ctx)
+	if err := seedDevices(ctx); err != nil {
+		return err
+	}
+
+	dev, err := db.GetDevice(ctx, name)
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("unknown device %q", name)
+	} else if err != nil {
+		return err
+	}
+
+	// What the flags change is stored together with the new password, and
+	// only once config.h has rendered.
+	save := db.FirmwareConfig{WiFiSSID: *ssidFlag, WiFiPassword: *wifiPassFlag, Force: *forceFlag}
+	if *hostnameFlag != "" {
+		save.Host = strings.TrimSuffix(*hostnameFlag, ".local") + ".local"
+		dev.Host = save.Host
+	}
+	if *pinsFlag != "" {
+		if name != "relays" {
+			return fmt.Errorf("--pins only applies to the relays board")
+		}
+		if save.RelayPins, err = parseRelayPins(*pinsFlag); err != nil {
+			return err
+		}
+	}
+
+	cfg := fwconfig.Config{
+		Device:       dev.Name,
+		OTAHostname:  dev.Hostname(),
+		OTAPassword:  dev.OTAPassword,
+		WiFiSSID:     save.WiFiSSID,
+		WiFiPassword: save.WiFiPassword,
+	}
+	if cfg.WiFiSSID == "" {
+		if cfg.WiFiSSID, err = db.GetSetting(ctx, db.SettingWiFiSSID); err != nil {
+			return err
+		}
+	}
+	if cfg.WiFiPassword == "" {
+		if cfg.WiFiPassword, err = db.GetSetting(ctx, db.SettingWiFiPassword); err != nil {
+			return err
+		}
+	}
+	if save.RelayPins != nil {
+		for _, pin := range save.RelayPins {
+			cfg.RelayPins = append(cfg.RelayPins, int(pin))
+		}
+	} else if name == "relays" {
+		if rels := db.ListRelays(ctx); rels != nil {
+			for _, r := range *rels {
+				if r.Pin == nil {
+					return fmt.Errorf("relay %d has no pin; set them with --pins", r.RelayIndex)
+				}
+				cfg.RelayPins = append(cfg.RelayPins, int(*r.Pin))
+			}
+		}
+	}
+
+	rotate := !*keepFlag || cfg.OTAPassword == ""
+	if rotate {
+		if dev.OTAPasswordPrev != "" && !*forceFlag {
+			return pendingRotation(name)
+		}
+		cfg.OTAPassword = rand.Text()
+		save.OTAPassword = cfg.OTAPassword
+	}
+
+	var out strings.Builder
+	if err := fwconfig.Render(&out, cfg); err != nil {
+		return fmt.Errorf("render config.h: %w (set --wifi-ssid/--wifi-password)", err)
+	}
+	// A file is staged next to the output so a failed save leaves the old
+	// one in place, and renamed over it once the records are stored.
+	var staged string
+	if *outFlag != "" {
+		if staged, err = stageConfig(*outFlag, out.String()); err != nil {
+			return err
+		}
+	}
+	if err := db.SaveFirmwareConfig(ctx, name, save); err != nil {
+		if staged != "" {
+			_ = os.Remove(staged)
+		}
+		if errors.Is(err, db.ErrOTARotationPending) {
+			return pendingRotation(name)
+		}
+		return err
+	}
+	if save.Host != "" {
+		fmt.Fprintf(os.Stderr, "%s: host set to %s\n", name, save.Host)
+	}
+	if rotate {
+		fmt.Fprintf(os.Stderr, "%s: generated new OTA password; previous one kept until the board accepts it\n", name)
+	}
+
+	if staged == "" {
+		if _, err := io.WriteString(os.Stdout, out.String()); err != nil {
+			return fmt.Errorf("write config.h: %w (render it again with --keep-password)", err)
+		}
+		return nil
+	}
+	if err := os.Rename(staged, *outFlag); err != nil {
+		return fmt.Errorf("%w (the new config.h is at %s)", err, staged)
+	}
+	fmt.Fprintf(os.Stderr, "%s: wrote %s\n", name, *outFlag)
+	return nil
+}
+
+func pendingRotation(name string) error {
+	return fmt.Errorf("%s: the OTA password from the last render hasn't been confirmed by an OTA push yet; "+
+		"flash that config.h (render it again with --keep-password), or pass --force if the board already runs it", name)
+}
+
+// stageConfig writes config.h to a new file in path's directory and returns
+// its name, for renaming over path.
+func stageConfig(path, s string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(f, s); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// parseRelayPins parses --pins: the physical pins of relays 1..8.
+func parseRelayPins(list string) ([]int64, error) {
+	parts := strings.Split(list, ",")
+	if len(parts) != 8 {
+		return nil, fmt.Errorf("--pins needs 8 pins, got %d", len(parts))
+	}
+	pins := make([]int64, len(parts))
+	for i, p := range parts {
+		pin, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+		if err != nil || pin < 1 || pin > 38 {
+			return nil, fmt.Errorf("invalid pin %q", p)
+		}
+		pins[i] = pin
+	}
+	return pins, nil
+}
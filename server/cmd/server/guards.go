@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+)
+
+// runGuard manages PIN/TOTP confirmation for sensitive actions:
+//
+//	server guard pin <action> [--pin=...]
+//	server guard totp <action>
+//	server guard clear <action>
+//	server guard list
+//	server guard failures
+func runGuard(args []string) error {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s guard pin <action> [--pin=...]   (PIN read from stdin if omitted)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s guard totp <action>            (prints the otpauth:// URI)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s guard clear <action>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s guard list|failures\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Actions: door, relay:<1-8>.")
+	}
+	if len(args) < 1 {
+		usage()
+		return fmt.Errorf("missing guard command")
+	}
+	cmd := args[0]
+
+	fs := flag.NewFlagSet("guard "+cmd, flag.ContinueOnError)
+	pinFlag := fs.String("pin", "", "PIN (read from stdin if empty)")
+	fs.Usage = usage
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	action := fs.Arg(0)
+	if fs.NArg() > 1 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	db.Connect(ctx)
+
+	switch cmd {
+	case "list":
+		guards, err := db.ListActionGuards(ctx)
+		if err != nil {
+			return err
+		}
+		for _, g := range guards {
+			var kinds []string
+			if g.PINHash != "" {
+				kinds = append(kinds, "pin")
+			}
+			if g.TOTPSecret != "" {
+				kinds = append(kinds, "totp")
+			}
+			fmt.Printf("%s\t%s\n", g.Action, strings.Join(kinds, "+"))
+		}
+		return nil
+	case "failures":
+		list, err := db.ListConfirmations(ctx, true, 100)
+		if err != nil {
+			return err
+		}
+		for _, c := range list {
+			fmt.Printf("%s\t%s\t%s\t%s\n", c.At.Local().Format("2006-01-02 15:04:05"), c.Action, c.Actor, c.IP)
+		}
+		return nil
+	case "pin", "totp", "clear":
+		if err := auth.ValidAction(action); err != nil {
+			usage()
+			return err
+		}
+	default:
+		usage()
+		return fmt.Errorf("unknown guard command %q", cmd)
+	}
+
+	switch cmd {
+	case "clear":
+		if err := db.DeleteActionGuard(ctx, action); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s no longer needs confirmation\n", action)
+
+	case "totp":
+		secret := auth.NewTOTPSecret()
+		if err := db.SetActionGuardTOTP(ctx, action, secret); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s now accepts TOTP codes; add this to an authenticator app:\n", action)
+		fmt.Println(auth.TOTPURI(secret, action))
+
+	case "pin":
+		pin := *pinFlag
+		if pin == "" {
+			fmt.Fprint(os.Stderr, "PIN: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("read PIN: %w", err)
+			}
+			pin = strings.TrimRight(line, "\r\n")
+		}
+		hash, err := auth.HashPIN(pin)
+		if err != nil {
+			return err
+		}
+		if err := db.SetActionGuardPIN(ctx, action, hash); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s now needs the PIN\n", action)
+	}
+	return nil
+}
@@ -8,17 +8,26 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
+	// schedule time zones must load on hosts without a zoneinfo database
+	_ "time/tzdata"
 
 	"relaypanel/internal/adb"
 	"relaypanel/internal/db"
 	"relaypanel/internal/device"
+	"relaypanel/internal/events"
 	"relaypanel/internal/logging"
+	"relaypanel/internal/ota"
 	"relaypanel/internal/router"
+	"relaypanel/internal/rules"
+	"relaypanel/internal/scene"
+	"relaypanel/internal/schedule"
 	"relaypanel/internal/telnet"
+	"relaypanel/internal/timer"
 
-	"github.com/tarm/serial"
+	"go.bug.st/serial"
 )
 
 const (
@@ -34,11 +43,30 @@ const (
 	DefaultSerialBaud = SerialBaudArduinoUNO
 
 	StatusInterval = 15 * time.Second
+
+	FirmwareDirName = "firmware"
+
+	TelemetryRetention = 30 * 24 * time.Hour
+
+	// ConfirmationRetention is how long PIN/TOTP attempts are kept for review.
+	ConfirmationRetention = 90 * 24 * time.Hour
+
+	// RelayHistoryRetention is how long relay switches are kept for the timeline.
+	RelayHistoryRetention = 90 * 24 * time.Hour
+
+	// AuditRetention is how long the audit log keeps relay, door and TV operations.
+	AuditRetention = 365 * 24 * time.Hour
+
+	// TimerRetention is how long ended relay timers are kept.
+	TimerRetention = 30 * 24 * time.Hour
+
+	// DefaultTVPoll is how often the TV's power and playback are checked.
+	DefaultTVPoll = 15 * time.Second
 )
 
-func dialMultiTelnet(mgr *device.Manager) error {
-	mgr.SetDialer("relays", func() (io.ReadWriteCloser, error) { return telnet.DialTelnet(RelaysESP32Host) })
-	mgr.SetDialer("buzzer", func() (io.ReadWriteCloser, error) { return telnet.DialTelnet(BuzzerESP32Host) })
+func dialMultiTelnet(mgr *device.Manager, relaysHost, buzzerHost string) error {
+	mgr.SetDialer("relays", func() (io.ReadWriteCloser, error) { return telnet.DialTelnet(relaysHost) })
+	mgr.SetDialer("buzzer", func() (io.ReadWriteCloser, error) { return telnet.DialTelnet(buzzerHost) })
 
 	type dialResult struct {
 		name string
@@ -47,15 +75,15 @@ func dialMultiTelnet(mgr *device.Manager) error {
 	}
 	dialResultChan := make(chan dialResult, 2)
 
-	slog.Info("dialing relays", "host", RelaysESP32Host)
+	slog.Info("dialing relays", "host", relaysHost)
 	go func() {
-		c, err := telnet.DialTelnet(RelaysESP32Host)
+		c, err := telnet.DialTelnet(relaysHost)
 		dialResultChan <- dialResult{name: "relays", conn: c, err: err}
 	}()
 
-	slog.Info("dialing buzzer", "host", BuzzerESP32Host)
+	slog.Info("dialing buzzer", "host", buzzerHost)
 	go func() {
-		c, err := telnet.DialTelnet(BuzzerESP32Host)
+		c, err := telnet.DialTelnet(buzzerHost)
 		dialResultChan <- dialResult{name: "buzzer", conn: c, err: err}
 	}()
 
@@ -79,12 +107,29 @@ func dialMultiTelnet(mgr *device.Manager) error {
 	}
 
 	mgr.SetDevice("relays", relays)
-	slog.Info("connected to relays", "host", RelaysESP32Host)
+	slog.Info("connected to relays", "host", relaysHost)
 	mgr.SetDevice("buzzer", buzzer)
-	slog.Info("connected to buzzer", "host", BuzzerESP32Host)
+	slog.Info("connected to buzzer", "host", buzzerHost)
 	return nil
 }
 
+// seedDevices creates the device records for both boards on first start.
+func seedDevices(ctx context.Context) error {
+	if err := db.EnsureDevice(ctx, "relays", RelaysESP32Host); err != nil {
+		return fmt.Errorf("failed to seed relays device: %w", err)
+	}
+	if err := db.EnsureDevice(ctx, "buzzer", BuzzerESP32Host); err != nil {
+		return fmt.Errorf("failed to seed buzzer device: %w", err)
+	}
+	return nil
+}
+
+// openSerial opens a serial port in blocking mode. The returned port also
+// exposes DTR/RTS control for hardware resets.
+func openSerial(name string, baud int) (io.ReadWriteCloser, error) {
+	return serial.Open(name, &serial.Mode{BaudRate: baud})
+}
+
 func Run() error {
 	logging.Setup()
 
@@ -92,6 +137,25 @@ func Run() error {
 	telnetFlag := flag.String("telnet", "", "telnet address host:port")
 	baudFlag := flag.Int("baud", DefaultSerialBaud, "serial baud rate")
 	multiFlag := flag.Bool("multi", false, "connect to both relays and buzzer ESP32s (no args)")
+	legacyGetFlag := flag.Bool("legacy-get", false, "also accept deprecated GET requests for relay/door/tv actions")
+	sessionTTLFlag := flag.Duration("session-ttl", router.DefaultSessionTTL, "how long a panel login stays valid")
+	rateLimits := router.DefaultRateLimits()
+	flag.Func("rate-limit-client", "requests per caller across all actions, N/duration or off (default "+rateLimits.Client.String()+")", func(s string) (err error) {
+		rateLimits.Client, err = router.ParseRateLimit(s)
+		return err
+	})
+	flag.Func("rate-limit", "per-caller limits for one relay, the door or the TV, e.g. door=6/1m,relay=20/1m,tv=off", rateLimits.SetResources)
+	flag.Func("rate-limit-shared", "limits for one relay, the door or the TV across all callers together, e.g. door=10/1m,relay=30/1m", rateLimits.SetShared)
+	flag.Func("trusted-proxy", "reverse proxies besides loopback whose X-Real-IP is believed, e.g. 10.0.0.2,192.168.1.0/24", router.SetTrustedProxies)
+	flag.Func("location", "latitude,longitude for sunrise/sunset schedules, e.g. 52.52,13.405", func(s string) error {
+		c, err := schedule.ParseCoordinates(s)
+		if err == nil {
+			schedule.SetLocation(c)
+		}
+		return err
+	})
+	tvPollFlag := flag.Duration("tv-poll", DefaultTVPoll, "how often to ask the TV over adb whether it's on and playing, for rules; 0 disables")
+	idempotencyTTLFlag := flag.Duration("idempotency-ttl", router.DefaultIdempotencyTTL, "how long responses to Idempotency-Key requests are replayed")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [--serial COM] [--telnet host:port] [--baud BAUD] [--multi]\n\n", os.Args[0])
@@ -102,6 +166,10 @@ func Run() error {
 		fmt.Fprintln(os.Stderr, "  ", os.Args[0], "--telnet=192.168.1.50:23")
 		fmt.Fprintln(os.Stderr, "  # Multi telnet mode (connect to relays and buzzer ESPs):")
 		fmt.Fprintln(os.Stderr, "  ", os.Args[0], "--multi")
+		fmt.Fprintln(os.Stderr, "  # Create a panel login:")
+		fmt.Fprintln(os.Stderr, "  ", os.Args[0], "user add alice")
+		fmt.Fprintln(os.Stderr, "  # Render a board's config.h from its device record:")
+		fmt.Fprintln(os.Stderr, "  ", os.Args[0], "firmware-config relays -o ../devices/esp32-relay/config.h")
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "Options:")
 		flag.PrintDefaults()
@@ -133,10 +201,90 @@ func Run() error {
 	deviceManager.SetLabels(labels)
 	slog.Info("loaded relay labels from DB")
 
+	if err := seedDevices(context.Background()); err != nil {
+		return err
+	}
+
+	hub := events.NewHub(events.DefaultBacklog)
+	deviceManager.SetEventHandler(func(typ string, data any) {
+		hub.Publish(typ, data)
+	})
+	deviceManager.SetRelayHandler(func(c device.RelayChange) {
+		err := db.InsertRelayTransition(context.Background(), db.RelayTransition{
+			At:      c.At,
+			Prev:    int64(c.Prev),
+			Mask:    int64(c.Mask),
+			Command: c.Origin.Command,
+			Actor:   c.Origin.Actor,
+		})
+		if err != nil {
+			slog.Error("failed to store relay transition", "err", err)
+		}
+	})
+	deviceManager.SetTelemetryHandler(func(name string, t device.Telemetry) {
+		err := db.InsertTelemetry(context.Background(), db.TelemetrySample{
+			Device:     name,
+			RSSI:       t.RSSI,
+			Uptime:     t.Uptime,
+			FreeHeap:   t.FreeHeap,
+			RecordedAt: t.At,
+		})
+		if err != nil {
+			slog.Error("failed to store telemetry", "device", name, "err", err)
+		}
+	})
+	deviceManager.SetSensorHandler(func(r device.SensorReading) {
+		if err := db.RecordSensorReading(context.Background(), r.Device, r.Name, r.Unit, r.Value, r.At); err != nil {
+			slog.Error("failed to store sensor reading", "device", r.Device, "sensor", r.Name, "err", err)
+		}
+	})
+	go func() {
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for range t.C {
+			cutoff := time.Now().Add(-TelemetryRetention)
+			n, err := db.PruneTelemetry(context.Background(), cutoff)
+			if err != nil {
+				slog.Error("failed to prune telemetry", "err", err)
+			} else if n > 0 {
+				slog.Info("pruned telemetry", "rows", n)
+			}
+			n, err = db.PruneSensorReadings(context.Background(), cutoff)
+			if err != nil {
+				slog.Error("failed to prune sensor readings", "err", err)
+			} else if n > 0 {
+				slog.Info("pruned sensor readings", "rows", n)
+			}
+			if _, err := db.PruneSessions(context.Background()); err != nil {
+				slog.Error("failed to prune sessions", "err", err)
+			}
+			if _, err := db.PruneConfirmations(context.Background(), time.Now().Add(-ConfirmationRetention)); err != nil {
+				slog.Error("failed to prune confirmations", "err", err)
+			}
+			if _, err := db.PruneRelayHistory(context.Background(), time.Now().Add(-RelayHistoryRetention)); err != nil {
+				slog.Error("failed to prune relay history", "err", err)
+			}
+			if _, err := db.PruneAuditLog(context.Background(), time.Now().Add(-AuditRetention)); err != nil {
+				slog.Error("failed to prune audit log", "err", err)
+			}
+			if _, err := db.PruneRelayTimers(context.Background(), time.Now().Add(-TimerRetention)); err != nil {
+				slog.Error("failed to prune relay timers", "err", err)
+			}
+		}
+	}()
+
 	modeStr := "serial"
 
 	if *multiFlag {
-		err := dialMultiTelnet(deviceManager)
+		relaysDev, err := db.GetDevice(context.Background(), "relays")
+		if err != nil {
+			return fmt.Errorf("failed to load relays device: %w", err)
+		}
+		buzzerDev, err := db.GetDevice(context.Background(), "buzzer")
+		if err != nil {
+			return fmt.Errorf("failed to load buzzer device: %w", err)
+		}
+		err = dialMultiTelnet(deviceManager, relaysDev.Host, buzzerDev.Host)
 		if err != nil {
 			return err
 		}
@@ -153,11 +301,10 @@ func Run() error {
 		modeStr = "telnet"
 	} else {
 		deviceManager.SetDialer("relays", func() (io.ReadWriteCloser, error) {
-			c := &serial.Config{Name: *serialFlag, Baud: *baudFlag, ReadTimeout: time.Second}
-			return serial.OpenPort(c)
+			return openSerial(*serialFlag, *baudFlag)
 		})
 		slog.Info("dialing serial", "port", *serialFlag, "baud", *baudFlag)
-		dev, err := serial.OpenPort(&serial.Config{Name: *serialFlag, Baud: *baudFlag, ReadTimeout: time.Second})
+		dev, err := openSerial(*serialFlag, *baudFlag)
 		if err != nil {
 			return fmt.Errorf("failed to open serial %s@%d: %w", *serialFlag, *baudFlag, err)
 		}
@@ -228,7 +375,44 @@ func Run() error {
 	}()
 
 	adbClient := adb.NewClient() // use defaults; adjust in future if flags needed
-	api := &router.API{Devices: deviceManager, ADB: adbClient}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	otaUpdater := ota.NewUpdater(filepath.Join(filepath.Dir(exePath), FirmwareDirName))
+
+	if n, err := db.CountUsers(context.Background()); err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	} else if n == 0 {
+		slog.Warn("no users yet; create one with: " + os.Args[0] + " user add <name>")
+	}
+
+	api := &router.API{
+		Devices:        deviceManager,
+		Events:         hub,
+		ADB:            adbClient,
+		OTA:            otaUpdater,
+		Scenes:         &scene.Runner{Devices: deviceManager, ADB: adbClient},
+		LegacyGET:      *legacyGetFlag,
+		SessionTTL:     *sessionTTLFlag,
+		IdempotencyTTL: *idempotencyTTLFlag,
+		RateLimits:     rateLimits,
+	}
+	api.Scheduler = schedule.New(api.RunTarget)
+	api.Scheduler.Start(context.Background())
+	api.Timers = timer.New(api.RunTarget, hub)
+	api.Timers.Start(context.Background())
+	api.Rules = rules.New(api.RunTarget, deviceManager)
+	api.Rules.Start(context.Background(), hub)
+	if *tvPollFlag > 0 {
+		adbClient.Watch(context.Background(), *tvPollFlag, func(st adb.TVState) {
+			hub.Publish(events.TypeTV, st)
+		})
+	}
+	if api.LegacyGET {
+		slog.Warn("deprecated GET routes enabled for relay/door/tv actions")
+	}
 	r := router.Router(api)
 
 	addr := ":42069"
@@ -241,7 +425,20 @@ func Run() error {
 }
 
 func main() {
-	if err := Run(); err != nil {
+	var err error
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "firmware-config":
+		err = runFirmwareConfig(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "user":
+		err = runUser(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "token":
+		err = runToken(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "guard":
+		err = runGuard(os.Args[2:])
+	default:
+		err = Run()
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+)
+
+// runToken manages API tokens:
+//
+//	server token create <name> --scopes=relay:read,door:buzz [--user=alice]
+//	server token list
+//	server token revoke <id>
+func runToken(args []string) error {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s token create <name> --scopes=relay:read,relay:write:3,... [--user=<username>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s token list\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s token revoke <id>\n", os.Args[0])
+	}
+	if len(args) < 1 {
+		usage()
+		return fmt.Errorf("missing token command")
+	}
+	cmd := args[0]
+
+	fs := flag.NewFlagSet("token "+cmd, flag.ContinueOnError)
+	scopesFlag := fs.String("scopes", "", "comma separated scopes")
+	userFlag := fs.String("user", "", "user the token acts for")
+	fs.Usage = usage
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	arg := fs.Arg(0)
+	if fs.NArg() > 1 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	db.Connect(ctx)
+
+	switch cmd {
+	case "list":
+		tokens, err := db.ListAPITokens(ctx)
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			lastUsed := "never"
+			if t.LastUsedAt != nil {
+				lastUsed = t.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%d\t%s\t%s\tlast used %s\n", t.ID, t.Name, strings.Join(t.ScopeList(), ","), lastUsed)
+		}
+		return nil
+
+	case "create":
+		if arg == "" {
+			usage()
+			return fmt.Errorf("missing token name")
+		}
+		scopes, err := auth.ParseScopes(*scopesFlag)
+		if err != nil {
+			return err
+		}
+		var userID *int64
+		if *userFlag != "" {
+			u, err := db.GetUserByName(ctx, *userFlag)
+			if err != nil {
+				return fmt.Errorf("unknown user %q", *userFlag)
+			}
+			userID = &u.ID
+		}
+		secret, hash := auth.NewToken()
+		t, err := db.CreateAPIToken(ctx, arg, hash, scopes, userID)
+		if err != nil {
+			return fmt.Errorf("create token: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "created token %d (%s); it won't be shown again:\n", t.ID, t.Name)
+		fmt.Println(secret)
+		return nil
+
+	case "revoke":
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			usage()
+			return fmt.Errorf("invalid token id %q", arg)
+		}
+		if _, err := db.GetAPIToken(ctx, id); err != nil {
+			return fmt.Errorf("unknown token %d", id)
+		}
+		if err := db.DeleteAPIToken(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "revoked token %d\n", id)
+		return nil
+	}
+	usage()
+	return fmt.Errorf("unknown token command %q", cmd)
+}
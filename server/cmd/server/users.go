@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+)
+
+// runUser manages panel accounts:
+//
+//	server user add|passwd|delete <username> [--password=...] [--role=...]
+//	server user role <username> --role=admin|member
+//	server user grant|revoke <username> --scopes=relay:write:3,door:buzz
+//	server user list
+func runUser(args []string) error {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s user add|passwd|delete <username> [--password=...] [--role=admin|member]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s user role <username> --role=admin|member\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s user grant|revoke <username> --scopes=relay:write:3,door:buzz,tv:control\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s user list\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Without --password the password is read from stdin.")
+		fmt.Fprintln(os.Stderr, "The first user is an admin; later ones default to member.")
+	}
+	if len(args) < 1 {
+		usage()
+		return fmt.Errorf("missing user command")
+	}
+	cmd := args[0]
+
+	fs := flag.NewFlagSet("user "+cmd, flag.ContinueOnError)
+	passwordFlag := fs.String("password", "", "password (read from stdin if empty)")
+	roleFlag := fs.String("role", "", "admin or member")
+	scopesFlag := fs.String("scopes", "", "comma separated scopes to grant or revoke")
+	fs.Usage = usage
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	username := fs.Arg(0)
+	if fs.NArg() > 1 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	db.Connect(ctx)
+
+	switch cmd {
+	case "list":
+		users, err := db.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			perms, err := db.ListUserPermissions(ctx, u.ID)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Role, strings.Join(perms, ","),
+				u.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+	case "add", "passwd", "delete", "role", "grant", "revoke":
+		if username == "" {
+			usage()
+			return fmt.Errorf("missing username")
+		}
+	default:
+		usage()
+		return fmt.Errorf("unknown user command %q", cmd)
+	}
+
+	if *roleFlag != "" {
+		if err := auth.ValidRole(*roleFlag); err != nil {
+			return err
+		}
+	}
+
+	switch cmd {
+	case "delete", "role", "grant", "revoke":
+		u, err := db.GetUserByName(ctx, username)
+		if err != nil {
+			return fmt.Errorf("unknown user %q", username)
+		}
+		return manageUser(ctx, cmd, u, *roleFlag, *scopesFlag)
+	}
+
+	password := *passwordFlag
+	if password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if cmd == "add" {
+		role := *roleFlag
+		if role == "" {
+			n, err := db.CountUsers(ctx)
+			if err != nil {
+				return err
+			}
+			role = auth.RoleMember
+			if n == 0 {
+				role = auth.RoleAdmin
+			}
+		}
+		if _, err := db.CreateUser(ctx, username, hash, role); err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "created %s %s\n", role, username)
+		return nil
+	}
+
+	u, err := db.GetUserByName(ctx, username)
+	if err != nil {
+		return fmt.Errorf("unknown user %q", username)
+	}
+	if err := db.UpdateUserPassword(ctx, u.ID, hash); err != nil {
+		return err
+	}
+	// a new password logs the user out everywhere
+	if err := db.DeleteUserSessions(ctx, u.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "updated password for %s\n", username)
+	return nil
+}
+
+func manageUser(ctx context.Context, cmd string, u *db.User, role, scopes string) error {
+	switch cmd {
+	case "delete":
+		if err := db.DeleteUser(ctx, u.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "deleted user %s\n", u.Username)
+
+	case "role":
+		if role == "" {
+			return fmt.Errorf("missing --role")
+		}
+		if err := db.UpdateUserRole(ctx, u.ID, role); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s is now %s\n", u.Username, role)
+
+	case "grant", "revoke":
+		list, err := auth.ParseScopes(scopes)
+		if err != nil {
+			return err
+		}
+		verb := "granted"
+		if cmd == "grant" {
+			err = db.GrantUserPermissions(ctx, u.ID, list)
+		} else {
+			verb = "revoked"
+			err = db.RevokeUserPermissions(ctx, u.ID, list)
+		}
+		if err != nil {
+			return err
+		}
+		if u.Role == auth.RoleAdmin {
+			fmt.Fprintf(os.Stderr, "note: %s is an admin; permissions only apply to members\n", u.Username)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s for %s\n", verb, strings.Join(list, ","), u.Username)
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package adb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
 // Defaults for target device.
@@ -13,6 +15,18 @@ const (
 	DefaultPort = "33893"
 )
 
+// Errors returned by Client methods, wrapped with adb's output.
+var (
+	// ErrNotInstalled means the adb binary isn't on PATH.
+	ErrNotInstalled = errors.New("adb not installed")
+	// ErrUnreachable means the TV didn't answer or is offline.
+	ErrUnreachable = errors.New("tv unreachable")
+	// ErrUnauthorized means the TV hasn't accepted this host's adb key yet.
+	ErrUnauthorized = errors.New("adb not authorized on tv")
+	// ErrFailed covers any other adb failure.
+	ErrFailed = errors.New("adb command failed")
+)
+
 // Key codes (subset used by the app).
 type KeyCode int
 
@@ -41,6 +55,8 @@ const (
 	KeycodeMediaNext      KeyCode = 87
 	KeycodeMediaPrevious  KeyCode = 88
 	KeycodeMediaStop      KeyCode = 86
+	KeycodeMediaPlay      KeyCode = 126
+	KeycodeMediaPause     KeyCode = 127
 
 	// Menu and Settings
 	KeycodeMenu     KeyCode = 82
@@ -54,6 +70,33 @@ const (
 	KeycodeFavourite   KeyCode = 1554
 )
 
+// Actions maps the action names used by the HTTP and WebSocket APIs
+// (/tv/<name>) to key codes.
+var Actions = map[string]KeyCode{
+	"volume_up":        KeycodeVolumeUp,
+	"volume_down":      KeycodeVolumeDown,
+	"power":            KeycodePower,
+	"home":             KeycodeHome,
+	"back":             KeycodeBack,
+	"mic_mute":         KeycodeMute,
+	"media_play_pause": KeycodeMediaPlayPause,
+	"media_play":       KeycodeMediaPlay,
+	"media_pause":      KeycodeMediaPause,
+	"media_next":       KeycodeMediaNext,
+	"media_prev":       KeycodeMediaPrevious,
+	"media_stop":       KeycodeMediaStop,
+	"dpad_up":          KeycodeDpadUp,
+	"dpad_down":        KeycodeDpadDown,
+	"dpad_left":        KeycodeDpadLeft,
+	"dpad_right":       KeycodeDpadRight,
+	"dpad_center":      KeycodeDpadCenter,
+	"menu":             KeycodeMenu,
+	"settings":         KeycodeSettings,
+	"speaker_mute":     KeycodeSpeakerMute,
+	"input_source":     KeycodeInputSource,
+	"favourite":        KeycodeFavourite,
+}
+
 // Client wraps ADB target information.
 type Client struct {
 	Host string
@@ -78,12 +121,41 @@ func (c *Client) addr() string { return c.Host + ":" + c.Port }
 // connect is harmless if already connected.
 func (c *Client) connect(ctx context.Context) error {
 	cmd := exec.CommandContext(ctx, "adb", "connect", c.addr())
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("adb connect failed: %v - %s", err, out)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return cmdError("adb connect", classify(err, out), out)
+	}
+	// adb connect exits 0 even when it couldn't reach the target.
+	if o := string(out); strings.Contains(o, "failed to") || strings.Contains(o, "cannot connect") {
+		return cmdError("adb connect", ErrUnreachable, out)
 	}
 	return nil
 }
 
+// classify maps an adb failure onto one of the package errors when the
+// output makes the cause clear.
+func classify(err error, out []byte) error {
+	o := string(out)
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return ErrNotInstalled
+	case strings.Contains(o, "unauthorized"):
+		return ErrUnauthorized
+	case strings.Contains(o, "offline"), strings.Contains(o, "not found"), strings.Contains(o, "failed to connect"):
+		return ErrUnreachable
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrUnreachable
+	}
+	return fmt.Errorf("%w (%v)", ErrFailed, err)
+}
+
+func cmdError(cmd string, err error, out []byte) error {
+	if o := strings.TrimSpace(string(out)); o != "" {
+		return fmt.Errorf("%s failed: %w - %s", cmd, err, o)
+	}
+	return fmt.Errorf("%s failed: %w", cmd, err)
+}
+
 // sendKey connects (idempotent) and sends a single key event.
 func (c *Client) sendKey(ctx context.Context, code KeyCode) error {
 	if err := c.connect(ctx); err != nil {
@@ -91,7 +163,7 @@ func (c *Client) sendKey(ctx context.Context, code KeyCode) error {
 	}
 	cmd := exec.CommandContext(ctx, "adb", "-s", c.addr(), "shell", "input", "keyevent", fmt.Sprintf("%d", code))
 	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("adb shell failed: %v - %s", err, out)
+		return cmdError("adb shell", classify(err, out), out)
 	}
 	return nil
 }
@@ -206,6 +278,8 @@ func (c *Client) SendKey(ctx context.Context, code KeyCode) error {
 // │ Media Next Track             │ 87      │ Works in media apps.                                   │
 // │ Media Previous Track         │ 88      │ Works in media apps.                                   │
 // │ Media Stop                   │ 86      │ Works in media apps.                                   │
+// │ Media Play                   │ 126     │ Resumes only; no-op if already playing.                │
+// │ Media Pause                  │ 127     │ Pauses only; no-op if already paused.                  │
 // │ Settings                     │ 176     │ Opens settings menu.                                   │
 // │ Input Source Menu            │ 178     │ Opens input/select menu.                               │
 // │ DPAD Up                      │ 19      │ Navigation.                                            │
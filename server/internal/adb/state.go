@@ -0,0 +1,111 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TV power states.
+const (
+	PowerOn  = "on"
+	PowerOff = "off" // asleep or dozing
+)
+
+// TV playback states, from the active media session.
+const (
+	PlaybackPlaying = "playing"
+	PlaybackPaused  = "paused"
+	PlaybackStopped = "stopped" // also: no media session
+)
+
+// TVState is what the TV reports over adb. Power and Playback are empty
+// when it isn't Reachable.
+type TVState struct {
+	Reachable bool   `json:"reachable"`
+	Power     string `json:"power,omitempty"`
+	Playback  string `json:"playback,omitempty"`
+}
+
+// stateTimeout bounds one State query.
+const stateTimeout = 10 * time.Second
+
+var (
+	wakefulnessRe   = regexp.MustCompile(`mWakefulness=(\w+)`)
+	playbackStateRe = regexp.MustCompile(`state=PlaybackState \{state=(\d+)`)
+)
+
+// State asks the TV whether it's awake and whether anything is playing.
+func (c *Client) State(ctx context.Context) (TVState, error) {
+	if err := c.connect(ctx); err != nil {
+		return TVState{}, err
+	}
+	power, err := c.shell(ctx, "dumpsys", "power")
+	if err != nil {
+		return TVState{}, err
+	}
+	media, err := c.shell(ctx, "dumpsys", "media_session")
+	if err != nil {
+		return TVState{}, err
+	}
+	st := TVState{Reachable: true, Power: PowerOff, Playback: PlaybackStopped}
+	if m := wakefulnessRe.FindStringSubmatch(power); m != nil && m[1] == "Awake" {
+		st.Power = PowerOn
+	}
+	// PlaybackState codes: 2 paused, 3 playing, 4-6 seeking or buffering.
+	// The first session listed is the one that last had focus.
+	if m := playbackStateRe.FindStringSubmatch(media); m != nil {
+		switch m[1] {
+		case "3", "4", "5", "6":
+			st.Playback = PlaybackPlaying
+		case "2":
+			st.Playback = PlaybackPaused
+		}
+	}
+	return st, nil
+}
+
+func (c *Client) shell(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "adb", append([]string{"-s", c.addr(), "shell"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", cmdError("adb shell "+strings.Join(args, " "), classify(err, out), out)
+	}
+	return string(out), nil
+}
+
+// Watch polls the TV's state every interval until ctx ends and calls fn
+// with the first state and every change after it. An unreachable TV is a
+// state of its own. It gives up if adb isn't installed.
+func (c *Client) Watch(ctx context.Context, interval time.Duration, fn func(TVState)) {
+	go func() {
+		var last *TVState
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			qctx, cancel := context.WithTimeout(ctx, stateTimeout)
+			st, err := c.State(qctx)
+			cancel()
+			if errors.Is(err, ErrNotInstalled) {
+				slog.Warn("adb not installed; not watching the tv")
+				return
+			}
+			if err != nil && (last == nil || last.Reachable) {
+				slog.Info("tv unreachable", "err", err)
+			}
+			if last == nil || st != *last {
+				last = &st
+				fn(st)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"relaypanel/internal/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SessionCookie = "hektor_session"
+
+	MinPasswordLength = 8
+)
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// dummyHash is compared against when the user doesn't exist so a login for an
+// unknown name takes as long as one with a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("hektor-dummy-password"), bcrypt.DefaultCost)
+
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Authenticate checks a username/password pair.
+func Authenticate(ctx context.Context, username, password string) (*db.User, error) {
+	u, err := db.GetUserByName(ctx, username)
+	if errors.Is(err, db.ErrNotFound) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// NewToken returns a random secret and the hash it's stored under.
+func NewToken() (token, hash string) {
+	token = rand.Text()
+	return token, HashToken(token)
+}
+
+// HashToken is how secrets handed to clients are stored: only the SHA-256.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type ctxKey struct{}
+
+// WithUser attaches the authenticated user to ctx.
+func WithUser(ctx context.Context, u *db.User) context.Context {
+	return context.WithValue(ctx, ctxKey{}, u)
+}
+
+// UserFrom returns the authenticated user, or nil.
+func UserFrom(ctx context.Context) *db.User {
+	u, _ := ctx.Value(ctxKey{}).(*db.User)
+	return u
+}
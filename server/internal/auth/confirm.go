@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"relaypanel/internal/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Guarded actions: buzzing the door and switching a single relay.
+const ActionDoor = "door"
+
+func RelayAction(id string) string {
+	return "relay:" + id
+}
+
+const (
+	MinPINLength = 4
+
+	// MaxConfirmFailures wrong codes in a row lock the actor out of every
+	// guarded action for ConfirmLockout.
+	MaxConfirmFailures = 5
+	ConfirmLockout     = 15 * time.Minute
+)
+
+var (
+	ErrConfirmRequired = errors.New("confirmation code required")
+	ErrConfirmFailed   = errors.New("wrong confirmation code")
+)
+
+// LockedError is returned while an actor is locked out after too many wrong codes.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "too many wrong codes; locked until " + e.Until.Local().Format("15:04:05")
+}
+
+func ValidAction(action string) error {
+	if action == ActionDoor {
+		return nil
+	}
+	if id, ok := strings.CutPrefix(action, "relay:"); ok {
+		if n, err := strconv.Atoi(id); err == nil && n >= 1 && n <= 8 {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action %q (door or relay:<1-8>)", action)
+}
+
+func HashPIN(pin string) (string, error) {
+	if len(pin) < MinPINLength {
+		return "", fmt.Errorf("PIN must be at least %d characters", MinPINLength)
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Confirm checks code against the PIN or TOTP secret guarding action, if
+// any. Every attempt is recorded; actor is locked out after
+// MaxConfirmFailures wrong codes since their last success.
+func Confirm(ctx context.Context, action, actor, ip, code string) error {
+	g, err := db.GetActionGuard(ctx, action)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	failures, err := db.RecentConfirmationFailures(ctx, actor, now.Add(-ConfirmLockout))
+	if err != nil {
+		return err
+	}
+	if len(failures) >= MaxConfirmFailures {
+		// failures is newest first; the lockout lifts once the failure that
+		// made the count reach the limit drops out of the window.
+		return &LockedError{Until: failures[MaxConfirmFailures-1].At.Add(ConfirmLockout)}
+	}
+	if code == "" {
+		return ErrConfirmRequired
+	}
+
+	ok := false
+	if g.PINHash != "" && bcrypt.CompareHashAndPassword([]byte(g.PINHash), []byte(code)) == nil {
+		ok = true
+	}
+	if g.TOTPSecret != "" && CheckTOTP(g.TOTPSecret, code, now) {
+		ok = true
+	}
+	if err := db.InsertConfirmation(ctx, db.Confirmation{Action: action, Actor: actor, IP: ip, OK: ok, At: now}); err != nil {
+		return err
+	}
+	if !ok {
+		return ErrConfirmFailed
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"relaypanel/internal/db"
+)
+
+// Door policies decide who may buzz the door at the moment. Schedules can
+// switch between them, e.g. no guests at night.
+const (
+	DoorOpen     = "open"      // anyone permitted to buzz (the default)
+	DoorNoGuests = "no_guests" // guest links are refused
+	DoorLocked   = "locked"    // every buzz is refused
+)
+
+var ErrDoorLocked = errors.New("the door is locked by policy")
+
+func ValidDoorPolicy(p string) error {
+	switch p {
+	case DoorOpen, DoorNoGuests, DoorLocked:
+		return nil
+	}
+	return fmt.Errorf("unknown door policy %q (open, no_guests or locked)", p)
+}
+
+// DoorPolicy returns the current policy.
+func DoorPolicy(ctx context.Context) (string, error) {
+	p, err := db.GetSetting(ctx, db.SettingDoorPolicy)
+	if p == "" {
+		p = DoorOpen
+	}
+	return p, err
+}
+
+func SetDoorPolicy(ctx context.Context, p string) error {
+	if err := ValidDoorPolicy(p); err != nil {
+		return err
+	}
+	return db.SetSetting(ctx, db.SettingDoorPolicy, p)
+}
+
+// CheckDoor returns ErrDoorLocked if the policy doesn't let the caller buzz
+// right now; guest says whether they came through a guest link.
+func CheckDoor(ctx context.Context, guest bool) error {
+	p, err := DoorPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if p == DoorLocked || (guest && p == DoorNoGuests) {
+		return ErrDoorLocked
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"relaypanel/internal/db"
+)
+
+var ErrBadGuestToken = errors.New("invalid guest link")
+
+var (
+	guestKeyM sync.Mutex
+	guestKey  []byte
+)
+
+// guestLinkKey loads the signing key, creating it on first use.
+func guestLinkKey(ctx context.Context) ([]byte, error) {
+	guestKeyM.Lock()
+	defer guestKeyM.Unlock()
+	if guestKey != nil {
+		return guestKey, nil
+	}
+	k, err := db.GetSetting(ctx, db.SettingGuestLinkKey)
+	if err != nil {
+		return nil, err
+	}
+	if k == "" {
+		k = rand.Text() + rand.Text()
+		if err := db.SetSetting(ctx, db.SettingGuestLinkKey, k); err != nil {
+			return nil, err
+		}
+	}
+	guestKey = []byte(k)
+	return guestKey, nil
+}
+
+func guestSignature(key []byte, l *db.GuestLink) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("guest-link:" + strconv.FormatInt(l.ID, 10) + ":" + strconv.FormatInt(l.ExpiresAt.Unix(), 10)))
+	return mac.Sum(nil)[:16]
+}
+
+// GuestLinkToken is the secret part of a guest link's URL: the link id and an
+// HMAC over the id and expiry, so links can't be guessed or extended.
+func GuestLinkToken(ctx context.Context, l *db.GuestLink) (string, error) {
+	key, err := guestLinkKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(l.ID, 10) + "." + base64.RawURLEncoding.EncodeToString(guestSignature(key, l)), nil
+}
+
+// VerifyGuestLinkToken returns the link a token was issued for. It doesn't
+// check whether the link is still active.
+func VerifyGuestLinkToken(ctx context.Context, token string) (*db.GuestLink, error) {
+	idStr, sigStr, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrBadGuestToken
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, ErrBadGuestToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigStr)
+	if err != nil {
+		return nil, ErrBadGuestToken
+	}
+	key, err := guestLinkKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l, err := db.GetGuestLink(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrBadGuestToken
+	} else if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(sig, guestSignature(key, l)) {
+		return nil, ErrBadGuestToken
+	}
+	return l, nil
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"relaypanel/internal/db"
+)
+
+// Roles. Admins may do anything; members can see everything but only control
+// what they've been granted.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var memberScopes = []string{ScopeRelayRead, ScopeDevicesRead, ScopeSensorsRead, ScopeScenesRead, ScopeSchedulesRead, ScopeRulesRead}
+
+func ValidRole(role string) error {
+	if role != RoleAdmin && role != RoleMember {
+		return fmt.Errorf("unknown role %q (admin or member)", role)
+	}
+	return nil
+}
+
+// UserScopes returns what a user is allowed to do, or nil for admins, who
+// aren't limited.
+func UserScopes(ctx context.Context, u *db.User) ([]string, error) {
+	if u.Role == RoleAdmin {
+		return nil, nil
+	}
+	granted, err := db.ListUserPermissions(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string(nil), memberScopes...), granted...), nil
+}
+
+// Allowed reports whether the request's credentials cover scope: the token's
+// scopes if it came with one, and the permissions of the user behind it.
+func Allowed(ctx context.Context, scope string) (bool, error) {
+	if t := TokenFrom(ctx); t != nil && !HasScope(t.ScopeList(), scope) {
+		return false, nil
+	}
+	u := UserFrom(ctx)
+	if u == nil {
+		// CLI-made tokens don't belong to anyone; their scopes are all there is.
+		return TokenFrom(ctx) != nil, nil
+	}
+	scopes, err := UserScopes(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	return scopes == nil || HasScope(scopes, scope), nil
+}
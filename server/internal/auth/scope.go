@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"relaypanel/internal/db"
+)
+
+// Scopes a token can be granted. relay:write also covers relay:write:<n>,
+// which limits a token to a single relay.
+const (
+	ScopeRelayRead    = "relay:read"
+	ScopeRelayWrite   = "relay:write"
+	ScopeDoorBuzz     = "door:buzz"
+	ScopeTVControl    = "tv:control"
+	ScopeDevicesRead  = "devices:read"
+	ScopeDevicesWrite = "devices:write"
+	ScopeSensorsRead  = "sensors:read"
+	ScopeSensorsWrite = "sensors:write"
+	ScopeScenesRead   = "scenes:read"
+	ScopeScenesWrite  = "scenes:write"
+
+	ScopeSchedulesRead  = "schedules:read"
+	ScopeSchedulesWrite = "schedules:write"
+	ScopeRulesRead      = "rules:read"
+	ScopeRulesWrite     = "rules:write"
+)
+
+var knownScopes = []string{
+	ScopeRelayRead, ScopeRelayWrite, ScopeDoorBuzz, ScopeTVControl,
+	ScopeDevicesRead, ScopeDevicesWrite, ScopeSensorsRead, ScopeSensorsWrite,
+	ScopeScenesRead, ScopeScenesWrite, ScopeSchedulesRead, ScopeSchedulesWrite,
+	ScopeRulesRead, ScopeRulesWrite,
+}
+
+// RelayScope is the scope needed to switch one relay.
+func RelayScope(id string) string {
+	return ScopeRelayWrite + ":" + id
+}
+
+// ParseScopes splits a comma or space separated list and rejects unknown scopes.
+func ParseScopes(s string) ([]string, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	for _, f := range fields {
+		if err := validScope(f); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+func validScope(s string) error {
+	for _, k := range knownScopes {
+		if s == k {
+			return nil
+		}
+	}
+	if id, ok := strings.CutPrefix(s, ScopeRelayWrite+":"); ok {
+		if n, err := strconv.Atoi(id); err == nil && n >= 1 && n <= 8 {
+			return nil
+		}
+		return fmt.Errorf("invalid relay in scope %q", s)
+	}
+	return fmt.Errorf("unknown scope %q (known: %s, %s:<1-8>)", s, strings.Join(knownScopes, ", "), ScopeRelayWrite)
+}
+
+// HasScope reports whether granted covers want. A grant covers itself and
+// any narrower scope below it, e.g. relay:write covers relay:write:3.
+func HasScope(granted []string, want string) bool {
+	for _, g := range granted {
+		if g == want || strings.HasPrefix(want, g+":") {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKey struct{}
+
+// WithToken attaches the API token a request authenticated with.
+func WithToken(ctx context.Context, t *db.APIToken) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// TokenFrom returns the API token the request authenticated with, or nil for
+// session logins.
+func TokenFrom(ctx context.Context) *db.APIToken {
+	t, _ := ctx.Value(tokenKey{}).(*db.APIToken)
+	return t
+}
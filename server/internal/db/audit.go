@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Audit actor kinds.
+const (
+	ActorUser   = "user"
+	ActorToken  = "token"
+	ActorGuest  = "guest"
+	ActorSystem = "system"
+)
+
+// AuditEntry records one relay, door, TV or label operation and how it went.
+type AuditEntry struct {
+	ID        int64     `db:"id" json:"id"`
+	At        time.Time `db:"at" json:"at"`
+	Actor     string    `db:"actor" json:"actor"`
+	ActorKind string    `db:"actor_kind" json:"actor_kind"`
+	IP        string    `db:"ip" json:"ip"`
+	Action    string    `db:"action" json:"action"`
+	Target    string    `db:"target" json:"target"`
+	Params    string    `db:"params" json:"params,omitempty"` // JSON
+	Result    string    `db:"result" json:"result"`           // "ok" or an error code
+	Error     string    `db:"error" json:"error,omitempty"`
+	RequestID string    `db:"request_id" json:"request_id,omitempty"`
+}
+
+// AuditFilter narrows ListAuditEntries. Empty fields match everything;
+// Before pages backwards through IDs.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Result string // "ok", "failed" or a specific error code
+	From   time.Time
+	To     time.Time
+	Before int64
+	Limit  int
+}
+
+func InsertAuditEntry(ctx context.Context, e AuditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO audit_log (at, actor, actor_kind, ip, action, target, params, result, error, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.At.UTC(), e.Actor, e.ActorKind, e.IP, e.Action, e.Target, e.Params, e.Result, e.Error, e.RequestID)
+	return err
+}
+
+// ListAuditEntries returns matching entries, newest first.
+func ListAuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Target != "" {
+		add("target = ?", f.Target)
+	}
+	switch f.Result {
+	case "":
+	case "failed":
+		where = append(where, "result != 'ok'")
+	default:
+		add("result = ?", f.Result)
+	}
+	if !f.From.IsZero() {
+		add("at >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("at < ?", f.To.UTC())
+	}
+	if f.Before > 0 {
+		add("id < ?", f.Before)
+	}
+	q := `SELECT * FROM audit_log`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	var entries []AuditEntry
+	if err := DB.SelectContext(ctx, &entries, q, args...); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// PruneAuditLog deletes entries recorded before t.
+func PruneAuditLog(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM audit_log WHERE at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
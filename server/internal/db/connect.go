@@ -13,6 +13,22 @@ import (
 var DEFAULT_DB_NAME = ".abobarelaydb"
 var DB *sqlx.DB
 
+// defaultRelayPins are the physical header pins relays 1-8 are wired to on the
+// relay board.
+var defaultRelayPins = [8]int{35, 34, 33, 31, 30, 29, 28, 27}
+
+// addColumn adds a column to an existing table unless it's already there, for
+// databases created before the column existed.
+func addColumn(table, column, decl string) {
+	var n int
+	if err := DB.Get(&n, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column); err != nil {
+		panic(err)
+	}
+	if n == 0 {
+		DB.MustExec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	}
+}
+
 func Connect(ctx context.Context) *sqlx.DB {
 	exePath, err := os.Executable()
 	if err != nil {
@@ -22,7 +38,10 @@ func Connect(ctx context.Context) *sqlx.DB {
 	exeDir := filepath.Dir(exePath)
 	dbPath := filepath.Join(exeDir, DEFAULT_DB_NAME)
 
-	DB, err = sqlx.Open("sqlite", dbPath)
+	// Every pooled connection needs these, and writers from the device
+	// handlers and the API overlap, so wait on a locked database instead of
+	// failing with SQLITE_BUSY.
+	DB, err = sqlx.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
 	if err != nil {
 		panic(err)
 	}
@@ -38,6 +57,226 @@ func Connect(ctx context.Context) *sqlx.DB {
 		relay_index INTEGER UNIQUE,
 		label TEXT
 	)`)
+	addColumn("relays", "pin", "INTEGER")
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS relay_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at DATETIME NOT NULL,
+		prev INTEGER NOT NULL,
+		mask INTEGER NOT NULL,
+		command TEXT NOT NULL DEFAULT '',
+		actor TEXT NOT NULL DEFAULT ''
+	)`)
+	DB.MustExec(`CREATE INDEX IF NOT EXISTS relay_history_at ON relay_history (at)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS devices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		host TEXT NOT NULL,
+		ota_port INTEGER NOT NULL,
+		ota_password TEXT NOT NULL
+	)`)
+	addColumn("devices", "ota_password_prev", "TEXT NOT NULL DEFAULT ''")
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS firmware (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		md5 TEXT NOT NULL,
+		uploaded_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS firmware_updates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device TEXT NOT NULL REFERENCES devices(name),
+		firmware_id INTEGER NOT NULL REFERENCES firmware(id),
+		state TEXT NOT NULL,
+		error TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS telemetry (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device TEXT NOT NULL,
+		rssi INTEGER NOT NULL,
+		uptime_s INTEGER NOT NULL,
+		free_heap INTEGER NOT NULL,
+		recorded_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`CREATE INDEX IF NOT EXISTS telemetry_device_time ON telemetry (device, recorded_at)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS sensors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device TEXT NOT NULL,
+		name TEXT NOT NULL,
+		label TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		unit TEXT NOT NULL,
+		last_value REAL,
+		last_seen DATETIME,
+		UNIQUE (device, name)
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS sensor_readings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sensor_id INTEGER NOT NULL REFERENCES sensors(id) ON DELETE CASCADE,
+		value REAL NOT NULL,
+		recorded_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`CREATE INDEX IF NOT EXISTS sensor_readings_sensor_time ON sensor_readings (sensor_id, recorded_at)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	// accounts created before roles existed keep full access
+	addColumn("users", "role", "TEXT NOT NULL DEFAULT 'admin'")
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS user_permissions (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		scope TEXT NOT NULL,
+		PRIMARY KEY (user_id, scope)
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS action_guards (
+		action TEXT PRIMARY KEY,
+		pin_hash TEXT NOT NULL DEFAULT '',
+		totp_secret TEXT NOT NULL DEFAULT ''
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS confirmations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		ok BOOLEAN NOT NULL,
+		at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`CREATE INDEX IF NOT EXISTS confirmations_actor_time ON confirmations (actor, at)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at DATETIME NOT NULL,
+		actor TEXT NOT NULL,
+		actor_kind TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		params TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT ''
+	)`)
+	DB.MustExec(`CREATE INDEX IF NOT EXISTS audit_log_at ON audit_log (at)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS scenes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS scene_steps (
+		scene_id INTEGER NOT NULL REFERENCES scenes(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		states TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL DEFAULT '',
+		delay_ms INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (scene_id, position)
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		cron TEXT NOT NULL,
+		time_zone TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		on_missed TEXT NOT NULL DEFAULT 'skip',
+		missed_window_s INTEGER NOT NULL DEFAULT 0,
+		next_run_at DATETIME,
+		last_run_at DATETIME,
+		last_result TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		trigger TEXT NOT NULL,
+		conditions TEXT NOT NULL DEFAULT '[]',
+		actions TEXT NOT NULL,
+		cooldown_s INTEGER NOT NULL DEFAULT 0,
+		last_fired_at DATETIME,
+		last_result TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS relay_timers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		relay INTEGER NOT NULL,
+		end_state BOOLEAN NOT NULL,
+		ends_at DATETIME NOT NULL,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		finished_at DATETIME,
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT ''
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS guest_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		label TEXT NOT NULL,
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		max_uses INTEGER NOT NULL,
+		uses INTEGER NOT NULL DEFAULT 0,
+		revoked_at DATETIME
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS guest_link_uses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		link_id INTEGER NOT NULL REFERENCES guest_links(id) ON DELETE CASCADE,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		result TEXT NOT NULL,
+		used_at DATETIME NOT NULL
+	)`)
+	DB.MustExec(`
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME
+	)`)
 
 	tx := DB.MustBegin()
 	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO relays (relay_index, label) VALUES (?, ?)`)
@@ -51,6 +290,10 @@ func Connect(ctx context.Context) *sqlx.DB {
 			tx.Rollback()
 			panic(err)
 		}
+		if _, err := tx.Exec(`UPDATE relays SET pin = ? WHERE relay_index = ? AND pin IS NULL`, defaultRelayPins[i-1], i); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+type Device struct {
+	ID          int64  `db:"id" json:"id"`
+	Name        string `db:"name" json:"name"`
+	Host        string `db:"host" json:"host"`
+	OTAPort     int64  `db:"ota_port" json:"ota_port"`
+	OTAPassword string `db:"ota_password" json:"-"`
+
+	// OTAPasswordPrev is the password before the last rotation; boards not
+	// yet flashed with the new config.h still expect it.
+	OTAPasswordPrev string `db:"ota_password_prev" json:"-"`
+}
+
+const DefaultOTAPort = 3232
+
+// EnsureDevice inserts a device record if one with that name doesn't exist yet.
+func EnsureDevice(ctx context.Context, name, host string) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT OR IGNORE INTO devices (name, host, ota_port, ota_password) VALUES (?, ?, ?, '')`,
+		name, host, DefaultOTAPort)
+	return err
+}
+
+func GetDevice(ctx context.Context, name string) (*Device, error) {
+	var d Device
+	if err := DB.GetContext(ctx, &d, `SELECT * FROM devices WHERE name = ?`, name); err != nil {
+		return nil, dbErr(err)
+	}
+	return &d, nil
+}
+
+func ListDevices(ctx context.Context) ([]Device, error) {
+	var devs []Device
+	if err := DB.SelectContext(ctx, &devs, `SELECT * FROM devices ORDER BY name ASC`); err != nil {
+		return nil, err
+	}
+	return devs, nil
+}
+
+// Hostname is the mDNS name the board announces (OTA_HOSTNAME in config.h).
+func (d *Device) Hostname() string {
+	return strings.TrimSuffix(d.Host, ".local")
+}
+
+// ErrOTARotationPending is returned by SaveFirmwareConfig while the board
+// hasn't yet accepted the password from the last rotation.
+var ErrOTARotationPending = errors.New("previous OTA password rotation not confirmed")
+
+// FirmwareConfig is what rendering a board's config.h changes in its
+// records. Empty fields are left as they are.
+type FirmwareConfig struct {
+	Host         string
+	WiFiSSID     string
+	WiFiPassword string
+	// RelayPins are the physical pins of relays 1..8.
+	RelayPins []int64
+
+	// OTAPassword is a new OTA password. The current one is kept as the
+	// fallback until the board accepts the new one. While an earlier
+	// rotation is unconfirmed it's refused, since there's no telling which
+	// of the two the board runs; Force asserts the board runs the current one.
+	OTAPassword string
+	Force       bool
+}
+
+// SaveFirmwareConfig stores c for device name in one transaction, so a
+// refused rotation leaves the host, pins and WiFi settings alone too.
+func SaveFirmwareConfig(ctx context.Context, name string, c FirmwareConfig) error {
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var d Device
+	if err := tx.GetContext(ctx, &d, `SELECT * FROM devices WHERE name = ?`, name); err != nil {
+		return dbErr(err)
+	}
+	if c.OTAPassword != "" {
+		if d.OTAPasswordPrev != "" && !c.Force {
+			return ErrOTARotationPending
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE devices SET ota_password_prev = ota_password, ota_password = ? WHERE name = ?`,
+			c.OTAPassword, name); err != nil {
+			return err
+		}
+	}
+	if c.Host != "" {
+		if _, err := tx.ExecContext(ctx, `UPDATE devices SET host = ? WHERE name = ?`, c.Host, name); err != nil {
+			return err
+		}
+	}
+	for key, value := range map[string]string{SettingWiFiSSID: c.WiFiSSID, SettingWiFiPassword: c.WiFiPassword} {
+		if value == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+			key, value); err != nil {
+			return err
+		}
+	}
+	for i, pin := range c.RelayPins {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE relays SET pin = ? WHERE relay_index = ?`, pin, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClearPreviousOTAPassword drops the fallback once the board accepted the current password.
+func ClearPreviousOTAPassword(ctx context.Context, name string) error {
+	_, err := DB.ExecContext(ctx, `UPDATE devices SET ota_password_prev = '' WHERE name = ?`, name)
+	return err
+}
+
+func UpdateDeviceOTA(ctx context.Context, name, host string, port int64, password string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE devices SET host = ?, ota_port = ?, ota_password = ? WHERE name = ?`,
+		host, port, password, name)
+	return err
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when a lookup matches no row. It also matches
+	// sql.ErrNoRows for callers that check for that.
+	ErrNotFound error = notFoundError{}
+
+	// ErrConflict is returned when an insert collides with a unique column,
+	// e.g. a username that's taken.
+	ErrConflict = errors.New("already exists")
+)
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string        { return "not found" }
+func (notFoundError) Is(target error) bool { return target == sql.ErrNoRows }
+
+// dbErr translates driver errors into the package's typed errors.
+func dbErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case strings.Contains(err.Error(), "UNIQUE constraint failed"):
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type Firmware struct {
+	ID         int64     `db:"id" json:"id"`
+	Name       string    `db:"name" json:"name"`
+	Path       string    `db:"path" json:"-"`
+	Size       int64     `db:"size" json:"size"`
+	MD5        string    `db:"md5" json:"md5"`
+	UploadedAt time.Time `db:"uploaded_at" json:"uploaded_at"`
+}
+
+type FirmwareUpdate struct {
+	ID         int64      `db:"id" json:"id"`
+	Device     string     `db:"device" json:"device"`
+	FirmwareID int64      `db:"firmware_id" json:"firmware_id"`
+	Firmware   string     `db:"firmware" json:"firmware"`
+	MD5        string     `db:"md5" json:"md5"`
+	State      string     `db:"state" json:"state"`
+	Error      string     `db:"error" json:"error,omitempty"`
+	StartedAt  time.Time  `db:"started_at" json:"started_at"`
+	FinishedAt *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+func CreateFirmware(ctx context.Context, name, path string, size int64, md5 string) (*Firmware, error) {
+	fw := Firmware{Name: name, Path: path, Size: size, MD5: md5, UploadedAt: time.Now().UTC()}
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO firmware (name, path, size, md5, uploaded_at) VALUES (?, ?, ?, ?, ?)`,
+		fw.Name, fw.Path, fw.Size, fw.MD5, fw.UploadedAt)
+	if err != nil {
+		return nil, err
+	}
+	if fw.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return &fw, nil
+}
+
+func GetFirmware(ctx context.Context, id int64) (*Firmware, error) {
+	var fw Firmware
+	if err := DB.GetContext(ctx, &fw, `SELECT * FROM firmware WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &fw, nil
+}
+
+func ListFirmware(ctx context.Context) ([]Firmware, error) {
+	var fws []Firmware
+	if err := DB.SelectContext(ctx, &fws, `SELECT * FROM firmware ORDER BY uploaded_at DESC`); err != nil {
+		return nil, err
+	}
+	return fws, nil
+}
+
+func CreateFirmwareUpdate(ctx context.Context, device string, fw *Firmware) (int64, error) {
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO firmware_updates (device, firmware_id, state, error, started_at) VALUES (?, ?, 'pending', '', ?)`,
+		device, fw.ID, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func FinishFirmwareUpdate(ctx context.Context, id int64, state, errMsg string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE firmware_updates SET state = ?, error = ?, finished_at = ? WHERE id = ?`,
+		state, errMsg, time.Now().UTC(), id)
+	return err
+}
+
+// ListFirmwareUpdates returns the firmware history of a device, newest first.
+func ListFirmwareUpdates(ctx context.Context, device string) ([]FirmwareUpdate, error) {
+	var ups []FirmwareUpdate
+	err := DB.SelectContext(ctx, &ups, `
+		SELECT u.id, u.device, u.firmware_id, f.name AS firmware, f.md5, u.state, u.error, u.started_at, u.finished_at
+		FROM firmware_updates u JOIN firmware f ON f.id = u.firmware_id
+		WHERE u.device = ?
+		ORDER BY u.started_at DESC`, device)
+	if err != nil {
+		return nil, err
+	}
+	return ups, nil
+}
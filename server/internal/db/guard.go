@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// ActionGuard asks for a PIN or TOTP code before an action runs. Actions are
+// "door" or "relay:<n>".
+type ActionGuard struct {
+	Action     string `db:"action" json:"action"`
+	PINHash    string `db:"pin_hash" json:"-"`
+	TOTPSecret string `db:"totp_secret" json:"-"`
+}
+
+// Confirmation is one attempt at entering a guard's code.
+type Confirmation struct {
+	ID     int64     `db:"id" json:"id"`
+	Action string    `db:"action" json:"action"`
+	Actor  string    `db:"actor" json:"actor"`
+	IP     string    `db:"ip" json:"ip"`
+	OK     bool      `db:"ok" json:"ok"`
+	At     time.Time `db:"at" json:"at"`
+}
+
+func ListActionGuards(ctx context.Context) ([]ActionGuard, error) {
+	var guards []ActionGuard
+	if err := DB.SelectContext(ctx, &guards, `SELECT * FROM action_guards ORDER BY action`); err != nil {
+		return nil, err
+	}
+	return guards, nil
+}
+
+func GetActionGuard(ctx context.Context, action string) (*ActionGuard, error) {
+	var g ActionGuard
+	if err := DB.GetContext(ctx, &g, `SELECT * FROM action_guards WHERE action = ?`, action); err != nil {
+		return nil, dbErr(err)
+	}
+	return &g, nil
+}
+
+func SetActionGuardPIN(ctx context.Context, action, pinHash string) error {
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO action_guards (action, pin_hash) VALUES (?, ?)
+		ON CONFLICT (action) DO UPDATE SET pin_hash = excluded.pin_hash`, action, pinHash)
+	return err
+}
+
+func SetActionGuardTOTP(ctx context.Context, action, secret string) error {
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO action_guards (action, totp_secret) VALUES (?, ?)
+		ON CONFLICT (action) DO UPDATE SET totp_secret = excluded.totp_secret`, action, secret)
+	return err
+}
+
+func DeleteActionGuard(ctx context.Context, action string) error {
+	_, err := DB.ExecContext(ctx, `DELETE FROM action_guards WHERE action = ?`, action)
+	return err
+}
+
+func InsertConfirmation(ctx context.Context, c Confirmation) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO confirmations (action, actor, ip, ok, at) VALUES (?, ?, ?, ?, ?)`,
+		c.Action, c.Actor, c.IP, c.OK, c.At.UTC())
+	return err
+}
+
+// RecentConfirmationFailures returns an actor's failed attempts since their
+// last success, looking back no further than since, newest first.
+func RecentConfirmationFailures(ctx context.Context, actor string, since time.Time) ([]Confirmation, error) {
+	var out []Confirmation
+	err := DB.SelectContext(ctx, &out, `
+		SELECT * FROM confirmations
+		WHERE actor = ? AND NOT ok AND at >= ? AND at > COALESCE(
+			(SELECT MAX(at) FROM confirmations WHERE actor = ? AND ok), '')
+		ORDER BY at DESC`,
+		actor, since.UTC(), actor)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListConfirmations returns attempts newest first, failures only if failed is set.
+func ListConfirmations(ctx context.Context, failed bool, limit int) ([]Confirmation, error) {
+	var out []Confirmation
+	q := `SELECT * FROM confirmations`
+	if failed {
+		q += ` WHERE NOT ok`
+	}
+	if err := DB.SelectContext(ctx, &out, q+` ORDER BY at DESC LIMIT ?`, limit); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func PruneConfirmations(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM confirmations WHERE at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
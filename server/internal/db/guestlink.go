@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// GuestLink lets someone without an account buzz the door a limited number
+// of times until it expires or is revoked.
+type GuestLink struct {
+	ID        int64      `db:"id" json:"id"`
+	Label     string     `db:"label" json:"label"`
+	CreatedBy *int64     `db:"created_by" json:"created_by"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	MaxUses   int64      `db:"max_uses" json:"max_uses"`
+	Uses      int64      `db:"uses" json:"uses"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at"`
+}
+
+type GuestLinkUse struct {
+	ID        int64     `db:"id" json:"id"`
+	LinkID    int64     `db:"link_id" json:"link_id"`
+	IP        string    `db:"ip" json:"ip"`
+	UserAgent string    `db:"user_agent" json:"user_agent"`
+	Result    string    `db:"result" json:"result"`
+	UsedAt    time.Time `db:"used_at" json:"used_at"`
+}
+
+// Active reports whether the link can still be used at t.
+func (l *GuestLink) Active(t time.Time) bool {
+	return l.RevokedAt == nil && t.Before(l.ExpiresAt) && l.Uses < l.MaxUses
+}
+
+func CreateGuestLink(ctx context.Context, label string, createdBy *int64, expiresAt time.Time, maxUses int64) (*GuestLink, error) {
+	l := GuestLink{
+		Label:     label,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+		MaxUses:   maxUses,
+	}
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO guest_links (label, created_by, created_at, expires_at, max_uses) VALUES (?, ?, ?, ?, ?)`,
+		l.Label, l.CreatedBy, l.CreatedAt, l.ExpiresAt, l.MaxUses)
+	if err != nil {
+		return nil, err
+	}
+	if l.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func GetGuestLink(ctx context.Context, id int64) (*GuestLink, error) {
+	var l GuestLink
+	if err := DB.GetContext(ctx, &l, `SELECT * FROM guest_links WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &l, nil
+}
+
+func ListGuestLinks(ctx context.Context) ([]GuestLink, error) {
+	var links []GuestLink
+	if err := DB.SelectContext(ctx, &links, `SELECT * FROM guest_links ORDER BY id DESC`); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func RevokeGuestLink(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE guest_links SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC(), id)
+	return err
+}
+
+// ClaimGuestLinkUse counts one use against the link if it's still active,
+// reporting false once it's used up, expired or revoked.
+func ClaimGuestLinkUse(ctx context.Context, id int64) (bool, error) {
+	res, err := DB.ExecContext(ctx, `
+		UPDATE guest_links SET uses = uses + 1
+		WHERE id = ? AND revoked_at IS NULL AND expires_at > ? AND uses < max_uses`,
+		id, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseGuestLinkUse gives back a use claimed for a buzz that didn't happen.
+func ReleaseGuestLinkUse(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, `UPDATE guest_links SET uses = uses - 1 WHERE id = ? AND uses > 0`, id)
+	return err
+}
+
+func InsertGuestLinkUse(ctx context.Context, u GuestLinkUse) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO guest_link_uses (link_id, ip, user_agent, result, used_at) VALUES (?, ?, ?, ?, ?)`,
+		u.LinkID, u.IP, u.UserAgent, u.Result, u.UsedAt.UTC())
+	return err
+}
+
+func ListGuestLinkUses(ctx context.Context, id int64) ([]GuestLinkUse, error) {
+	var uses []GuestLinkUse
+	if err := DB.SelectContext(ctx, &uses,
+		`SELECT * FROM guest_link_uses WHERE link_id = ? ORDER BY used_at DESC`, id); err != nil {
+		return nil, err
+	}
+	return uses, nil
+}
@@ -6,6 +6,7 @@ type Relay struct {
 	ID         int64  `db:"id"`
 	Label      string `db:"label"`
 	RelayIndex int64  `db:"relay_index"`
+	Pin        *int64 `db:"pin"` // physical header pin on the relay board
 }
 
 func CreateRelay(ctx context.Context, label string, index int64) int64 {
@@ -24,7 +25,7 @@ func GetRelayByIndex(ctx context.Context, index int64) (*Relay, error) {
 	var relay Relay
 	err := DB.GetContext(ctx, &relay, `SELECT * FROM relays WHERE relay_index = ?`, index)
 	if err != nil {
-		return nil, err
+		return nil, dbErr(err)
 	}
 	return &relay, nil
 }
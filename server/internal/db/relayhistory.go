@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RelayTransition is a change in the reported relay bitmask; bit n-1 is relay n.
+type RelayTransition struct {
+	ID      int64     `db:"id" json:"id"`
+	At      time.Time `db:"at" json:"at"`
+	Prev    int64     `db:"prev" json:"prev"`
+	Mask    int64     `db:"mask" json:"mask"`
+	Command string    `db:"command" json:"command,omitempty"`
+	Actor   string    `db:"actor" json:"actor,omitempty"`
+}
+
+func InsertRelayTransition(ctx context.Context, t RelayTransition) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO relay_history (at, prev, mask, command, actor) VALUES (?, ?, ?, ?, ?)`,
+		t.At.UTC(), t.Prev, t.Mask, t.Command, t.Actor)
+	return err
+}
+
+// ListRelayTransitions returns transitions in [from, to), oldest first.
+func ListRelayTransitions(ctx context.Context, from, to time.Time) ([]RelayTransition, error) {
+	var ts []RelayTransition
+	err := DB.SelectContext(ctx, &ts,
+		`SELECT * FROM relay_history WHERE at >= ? AND at < ? ORDER BY at ASC, id ASC`,
+		from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// RelayMaskAt returns the bitmask in effect at t: the one set by the last
+// transition before it, or 0 if there is none.
+func RelayMaskAt(ctx context.Context, t time.Time) (int64, error) {
+	var mask int64
+	err := DB.GetContext(ctx, &mask,
+		`SELECT mask FROM relay_history WHERE at < ? ORDER BY at DESC, id DESC LIMIT 1`, t.UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return mask, err
+}
+
+// PruneRelayHistory deletes transitions recorded before t.
+func PruneRelayHistory(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM relay_history WHERE at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
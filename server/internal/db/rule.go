@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Rule runs Actions when an event matching Trigger happens and every one of
+// Conditions holds.
+type Rule struct {
+	ID         int64          `db:"id" json:"id"`
+	Name       string         `db:"name" json:"name"`
+	Enabled    bool           `db:"enabled" json:"enabled"`
+	Trigger    RuleTrigger    `db:"trigger" json:"trigger"`
+	Conditions RuleConditions `db:"conditions" json:"conditions"`
+	Actions    Targets        `db:"actions" json:"actions"`
+	// CooldownS is how long after firing the rule ignores its trigger.
+	CooldownS   int64      `db:"cooldown_s" json:"cooldown_s"`
+	LastFiredAt *time.Time `db:"last_fired_at" json:"last_fired_at"`
+	LastResult  string     `db:"last_result" json:"last_result,omitempty"` // "ok" or "failed"
+	LastError   string     `db:"last_error" json:"last_error,omitempty"`
+	CreatedBy   string     `db:"created_by" json:"created_by"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// RuleTrigger is the event a rule waits for. Which fields apply depends on
+// Event; see the rules package.
+type RuleTrigger struct {
+	Event  string `json:"event"`
+	Device string `json:"device,omitempty"`
+	Relay  int    `json:"relay,omitempty"`
+	State  string `json:"state,omitempty"`
+}
+
+// RuleCondition is something that must hold when a rule's trigger fires.
+// Which fields apply depends on Type; see the rules package.
+type RuleCondition struct {
+	Type     string `json:"type"`
+	Relay    int    `json:"relay,omitempty"`
+	Device   string `json:"device,omitempty"`
+	State    string `json:"state,omitempty"`
+	MinRSSI  int    `json:"min_rssi,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+type RuleConditions []RuleCondition
+
+// Targets is a list of commands stored for later, kept as JSON.
+type Targets []Target
+
+func (t RuleTrigger) Value() (driver.Value, error) { return jsonValue(t) }
+func (t *RuleTrigger) Scan(v any) error            { return scanJSON("rule trigger", v, t) }
+
+func (c RuleConditions) Value() (driver.Value, error) {
+	if c == nil {
+		c = RuleConditions{}
+	}
+	return jsonValue(c)
+}
+func (c *RuleConditions) Scan(v any) error { return scanJSON("rule conditions", v, c) }
+
+func (t Targets) Value() (driver.Value, error) {
+	if t == nil {
+		t = Targets{}
+	}
+	return jsonValue(t)
+}
+func (t *Targets) Scan(v any) error { return scanJSON("targets", v, t) }
+
+func jsonValue(v any) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func scanJSON(what string, v, dst any) error {
+	switch v := v.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	case []byte:
+		return json.Unmarshal(v, dst)
+	}
+	return fmt.Errorf("%s: unexpected %T", what, v)
+}
+
+func CreateRule(ctx context.Context, ru *Rule) error {
+	now := time.Now().UTC()
+	ru.CreatedAt, ru.UpdatedAt = now, now
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO rules (name, enabled, trigger, conditions, actions, cooldown_s, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ru.Name, ru.Enabled, ru.Trigger, ru.Conditions, ru.Actions, ru.CooldownS, ru.CreatedBy, now, now)
+	if err != nil {
+		return dbErr(err)
+	}
+	ru.ID, err = res.LastInsertId()
+	return err
+}
+
+// UpdateRule stores the editable fields of ru.
+func UpdateRule(ctx context.Context, ru *Rule) error {
+	ru.UpdatedAt = time.Now().UTC()
+	res, err := DB.ExecContext(ctx, `
+		UPDATE rules SET name = ?, enabled = ?, trigger = ?, conditions = ?, actions = ?, cooldown_s = ?, updated_at = ?
+		WHERE id = ?`,
+		ru.Name, ru.Enabled, ru.Trigger, ru.Conditions, ru.Actions, ru.CooldownS, ru.UpdatedAt, ru.ID)
+	if err != nil {
+		return dbErr(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func GetRule(ctx context.Context, id int64) (*Rule, error) {
+	var ru Rule
+	if err := DB.GetContext(ctx, &ru, `SELECT * FROM rules WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &ru, nil
+}
+
+func ListRules(ctx context.Context) ([]Rule, error) {
+	var out []Rule
+	err := DB.SelectContext(ctx, &out, `SELECT * FROM rules ORDER BY name`)
+	return out, err
+}
+
+func DeleteRule(ctx context.Context, id int64) error {
+	res, err := DB.ExecContext(ctx, `DELETE FROM rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordRuleRun stores how the run that fired at at went.
+func RecordRuleRun(ctx context.Context, id int64, at time.Time, result, errMsg string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE rules SET last_fired_at = ?, last_result = ?, last_error = ? WHERE id = ?`,
+		at.UTC(), result, errMsg, id)
+	return err
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Scene is a named list of steps run in order, e.g. "Movie night".
+type Scene struct {
+	ID        int64       `db:"id" json:"id"`
+	Name      string      `db:"name" json:"name"`
+	CreatedAt time.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time   `db:"updated_at" json:"updated_at"`
+	Steps     []SceneStep `db:"-" json:"steps"`
+}
+
+// SceneStep is one command of a scene, run after waiting DelayMS. Which
+// fields apply depends on Type; see the scene package.
+type SceneStep struct {
+	SceneID  int64       `db:"scene_id" json:"-"`
+	Position int         `db:"position" json:"-"`
+	Type     string      `db:"type" json:"type"`
+	States   RelayStates `db:"states" json:"states,omitempty"`
+	Action   string      `db:"action" json:"action,omitempty"`
+	DelayMS  int64       `db:"delay_ms" json:"delay_ms,omitempty"`
+}
+
+// RelayStates maps relay numbers ("1".."8") to on/off. It's stored as JSON.
+type RelayStates map[string]bool
+
+func (s RelayStates) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *RelayStates) Scan(v any) error {
+	var b []byte
+	switch v := v.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case nil:
+	default:
+		return fmt.Errorf("relay states: unexpected %T", v)
+	}
+	*s = nil
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, s)
+}
+
+func CreateScene(ctx context.Context, name string, steps []SceneStep) (*Scene, error) {
+	now := time.Now().UTC()
+	sc := Scene{Name: name, CreatedAt: now, UpdatedAt: now, Steps: steps}
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO scenes (name, created_at, updated_at) VALUES (?, ?, ?)`, sc.Name, sc.CreatedAt, sc.UpdatedAt)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	if sc.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	if err := insertSceneSteps(ctx, tx, sc.ID, steps); err != nil {
+		return nil, err
+	}
+	return &sc, tx.Commit()
+}
+
+// UpdateScene renames a scene and replaces its steps.
+func UpdateScene(ctx context.Context, id int64, name string, steps []SceneStep) error {
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx,
+		`UPDATE scenes SET name = ?, updated_at = ? WHERE id = ?`, name, time.Now().UTC(), id)
+	if err != nil {
+		return dbErr(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM scene_steps WHERE scene_id = ?`, id); err != nil {
+		return err
+	}
+	if err := insertSceneSteps(ctx, tx, id, steps); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertSceneSteps(ctx context.Context, tx *sqlx.Tx, sceneID int64, steps []SceneStep) error {
+	for i, st := range steps {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO scene_steps (scene_id, position, type, states, action, delay_ms) VALUES (?, ?, ?, ?, ?, ?)`,
+			sceneID, i, st.Type, st.States, st.Action, st.DelayMS)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetScene(ctx context.Context, id int64) (*Scene, error) {
+	var sc Scene
+	if err := DB.GetContext(ctx, &sc, `SELECT * FROM scenes WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	if err := DB.SelectContext(ctx, &sc.Steps,
+		`SELECT * FROM scene_steps WHERE scene_id = ? ORDER BY position`, id); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+func ListScenes(ctx context.Context) ([]Scene, error) {
+	var scenes []Scene
+	if err := DB.SelectContext(ctx, &scenes, `SELECT * FROM scenes ORDER BY name`); err != nil {
+		return nil, err
+	}
+	var steps []SceneStep
+	if err := DB.SelectContext(ctx, &steps, `SELECT * FROM scene_steps ORDER BY scene_id, position`); err != nil {
+		return nil, err
+	}
+	byScene := make(map[int64][]SceneStep)
+	for _, st := range steps {
+		byScene[st.SceneID] = append(byScene[st.SceneID], st)
+	}
+	for i := range scenes {
+		scenes[i].Steps = byScene[scenes[i].ID]
+	}
+	return scenes, nil
+}
+
+func DeleteScene(ctx context.Context, id int64) error {
+	res, err := DB.ExecContext(ctx, `DELETE FROM scenes WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Missed run policies: what a schedule does about runs that fell due while
+// the server was down.
+const (
+	MissedSkip    = "skip"     // wait for the next run
+	MissedRunOnce = "run_once" // run once on start, however many were missed
+)
+
+// Schedule runs Target whenever Cron matches, in TimeZone.
+type Schedule struct {
+	ID       int64  `db:"id" json:"id"`
+	Name     string `db:"name" json:"name"`
+	Cron     string `db:"cron" json:"cron"`
+	TimeZone string `db:"time_zone" json:"time_zone"` // IANA name; "" is the server's zone
+	Target   Target `db:"target" json:"target"`
+	Enabled  bool   `db:"enabled" json:"enabled"`
+	OnMissed string `db:"on_missed" json:"on_missed"`
+	// MissedWindowS limits MissedRunOnce to runs missed at most this many
+	// seconds ago; 0 means no limit.
+	MissedWindowS int64      `db:"missed_window_s" json:"missed_window_s"`
+	NextRunAt     *time.Time `db:"next_run_at" json:"next_run_at"`
+	LastRunAt     *time.Time `db:"last_run_at" json:"last_run_at"`
+	LastResult    string     `db:"last_result" json:"last_result,omitempty"` // "ok", "failed" or "missed"
+	LastError     string     `db:"last_error" json:"last_error,omitempty"`
+	CreatedBy     string     `db:"created_by" json:"created_by"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+func CreateSchedule(ctx context.Context, s *Schedule) error {
+	now := time.Now().UTC()
+	s.CreatedAt, s.UpdatedAt, s.NextRunAt = now, now, utcPtr(s.NextRunAt)
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO schedules (name, cron, time_zone, target, enabled, on_missed, missed_window_s, next_run_at, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.Name, s.Cron, s.TimeZone, s.Target, s.Enabled, s.OnMissed, s.MissedWindowS, s.NextRunAt, s.CreatedBy, now, now)
+	if err != nil {
+		return dbErr(err)
+	}
+	s.ID, err = res.LastInsertId()
+	return err
+}
+
+// UpdateSchedule stores the editable fields of s and its next run.
+func UpdateSchedule(ctx context.Context, s *Schedule) error {
+	s.UpdatedAt, s.NextRunAt = time.Now().UTC(), utcPtr(s.NextRunAt)
+	res, err := DB.ExecContext(ctx, `
+		UPDATE schedules SET name = ?, cron = ?, time_zone = ?, target = ?, enabled = ?, on_missed = ?,
+			missed_window_s = ?, next_run_at = ?, updated_at = ?
+		WHERE id = ?`,
+		s.Name, s.Cron, s.TimeZone, s.Target, s.Enabled, s.OnMissed, s.MissedWindowS, s.NextRunAt, s.UpdatedAt, s.ID)
+	if err != nil {
+		return dbErr(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func GetSchedule(ctx context.Context, id int64) (*Schedule, error) {
+	var s Schedule
+	if err := DB.GetContext(ctx, &s, `SELECT * FROM schedules WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &s, nil
+}
+
+func ListSchedules(ctx context.Context) ([]Schedule, error) {
+	var out []Schedule
+	err := DB.SelectContext(ctx, &out, `SELECT * FROM schedules ORDER BY name`)
+	return out, err
+}
+
+func DeleteSchedule(ctx context.Context, id int64) error {
+	res, err := DB.ExecContext(ctx, `DELETE FROM schedules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetScheduleNextRun moves a schedule on without running it.
+func SetScheduleNextRun(ctx context.Context, id int64, next *time.Time) error {
+	_, err := DB.ExecContext(ctx, `UPDATE schedules SET next_run_at = ? WHERE id = ?`, utcPtr(next), id)
+	return err
+}
+
+// RecordScheduleRun stores how the run at at went.
+func RecordScheduleRun(ctx context.Context, id int64, at time.Time, result, errMsg string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE schedules SET last_run_at = ?, last_result = ?, last_error = ? WHERE id = ?`,
+		at.UTC(), result, errMsg, id)
+	return err
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type Sensor struct {
+	ID        int64      `db:"id" json:"id"`
+	Device    string     `db:"device" json:"device"`
+	Name      string     `db:"name" json:"name"`
+	Label     string     `db:"label" json:"label"`
+	Kind      string     `db:"kind" json:"kind"`
+	Unit      string     `db:"unit" json:"unit"`
+	LastValue *float64   `db:"last_value" json:"last_value"`
+	LastSeen  *time.Time `db:"last_seen" json:"last_seen"`
+}
+
+type SensorReading struct {
+	Value      float64   `db:"value" json:"value"`
+	RecordedAt time.Time `db:"recorded_at" json:"at"`
+}
+
+// RecordSensorReading registers the sensor on first sight, updates its last
+// value and appends the reading to its history. A unit reported by the board
+// only fills in an empty unit; units set through the API win.
+func RecordSensorReading(ctx context.Context, device, name, unit string, value float64, at time.Time) error {
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sensors (device, name, label, kind, unit, last_value, last_seen)
+		VALUES (?, ?, '', 'generic', ?, ?, ?)
+		ON CONFLICT (device, name) DO UPDATE SET
+			unit = CASE WHEN sensors.unit = '' THEN excluded.unit ELSE sensors.unit END,
+			last_value = excluded.last_value,
+			last_seen = excluded.last_seen`,
+		device, name, unit, value, at.UTC())
+	if err != nil {
+		return err
+	}
+	var id int64
+	if err := tx.GetContext(ctx, &id, `SELECT id FROM sensors WHERE device = ? AND name = ?`, device, name); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO sensor_readings (sensor_id, value, recorded_at) VALUES (?, ?, ?)`,
+		id, value, at.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ListSensors(ctx context.Context) ([]Sensor, error) {
+	var sensors []Sensor
+	if err := DB.SelectContext(ctx, &sensors, `SELECT * FROM sensors ORDER BY device, name`); err != nil {
+		return nil, err
+	}
+	return sensors, nil
+}
+
+func GetSensor(ctx context.Context, id int64) (*Sensor, error) {
+	var s Sensor
+	if err := DB.GetContext(ctx, &s, `SELECT * FROM sensors WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &s, nil
+}
+
+func UpdateSensor(ctx context.Context, id int64, label, kind, unit string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE sensors SET label = ?, kind = ?, unit = ? WHERE id = ?`, label, kind, unit, id)
+	return err
+}
+
+// ListSensorReadings returns readings in [from, to), oldest first.
+func ListSensorReadings(ctx context.Context, id int64, from, to time.Time, limit int) ([]SensorReading, error) {
+	var readings []SensorReading
+	err := DB.SelectContext(ctx, &readings, `
+		SELECT value, recorded_at FROM (
+			SELECT * FROM sensor_readings
+			WHERE sensor_id = ? AND recorded_at >= ? AND recorded_at < ?
+			ORDER BY recorded_at DESC LIMIT ?
+		) ORDER BY recorded_at ASC`, id, from.UTC(), to.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// PruneSensorReadings deletes readings recorded before t.
+func PruneSensorReadings(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM sensor_readings WHERE recorded_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const (
+	SettingWiFiSSID     = "wifi_ssid"
+	SettingWiFiPassword = "wifi_password"
+
+	// SettingGuestLinkKey signs door guest links.
+	SettingGuestLinkKey = "guest_link_key"
+
+	// SettingDoorPolicy is the current auth door policy.
+	SettingDoorPolicy = "door_policy"
+)
+
+// GetSetting returns the value stored under key, or "" if it was never set.
+func GetSetting(ctx context.Context, key string) (string, error) {
+	var v string
+	err := DB.GetContext(ctx, &v, `SELECT value FROM settings WHERE key = ?`, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return v, err
+}
+
+func SetSetting(ctx context.Context, key, value string) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		key, value)
+	return err
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Target types: what a schedule (or other automation) does when it fires.
+const (
+	TargetRelays     = "relays"      // set States, or apply a relay Action
+	TargetDoorPolicy = "door_policy" // switch to Policy
+	TargetScene      = "scene"       // run Steps, copied from SceneID when stored
+	TargetTV         = "tv"          // press the adb.Actions key named by Action
+	TargetDoor       = "door"        // buzz the door
+)
+
+// Target is a command stored for later, kept as JSON.
+type Target struct {
+	Type    string      `json:"type"`
+	States  RelayStates `json:"states,omitempty"`
+	Action  string      `json:"action,omitempty"`
+	Policy  string      `json:"policy,omitempty"`
+	SceneID int64       `json:"scene_id,omitempty"`
+	// Steps are the scene's steps as they were when the target was stored,
+	// and what runs: editing the scene later doesn't change the target.
+	Steps []SceneStep `json:"steps,omitempty"`
+}
+
+func (t Target) Value() (driver.Value, error) {
+	b, err := json.Marshal(t)
+	return string(b), err
+}
+
+func (t *Target) Scan(v any) error {
+	switch v := v.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case []byte:
+		return json.Unmarshal(v, t)
+	}
+	return fmt.Errorf("target: unexpected %T", v)
+}
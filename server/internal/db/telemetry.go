@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type TelemetrySample struct {
+	Device     string    `db:"device" json:"-"`
+	RSSI       int       `db:"rssi" json:"rssi"`
+	Uptime     int64     `db:"uptime_s" json:"uptime_s"`
+	FreeHeap   int64     `db:"free_heap" json:"free_heap"`
+	RecordedAt time.Time `db:"recorded_at" json:"at"`
+}
+
+func InsertTelemetry(ctx context.Context, s TelemetrySample) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO telemetry (device, rssi, uptime_s, free_heap, recorded_at) VALUES (?, ?, ?, ?, ?)`,
+		s.Device, s.RSSI, s.Uptime, s.FreeHeap, s.RecordedAt)
+	return err
+}
+
+// ListTelemetry returns samples for a device in [from, to), oldest first.
+func ListTelemetry(ctx context.Context, device string, from, to time.Time, limit int) ([]TelemetrySample, error) {
+	var samples []TelemetrySample
+	err := DB.SelectContext(ctx, &samples, `
+		SELECT device, rssi, uptime_s, free_heap, recorded_at FROM (
+			SELECT * FROM telemetry
+			WHERE device = ? AND recorded_at >= ? AND recorded_at < ?
+			ORDER BY recorded_at DESC LIMIT ?
+		) ORDER BY recorded_at ASC`, device, from.UTC(), to.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// PruneTelemetry deletes samples recorded before t.
+func PruneTelemetry(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM telemetry WHERE recorded_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// RelayTimer switches a relay to EndState at EndsAt. Result is empty while
+// the timer is active, then "ok", "failed", "cancelled" or "replaced".
+type RelayTimer struct {
+	ID         int64      `db:"id" json:"id"`
+	Relay      int        `db:"relay" json:"relay"`
+	EndState   bool       `db:"end_state" json:"end_state"`
+	EndsAt     time.Time  `db:"ends_at" json:"ends_at"`
+	CreatedBy  string     `db:"created_by" json:"created_by"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	FinishedAt *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+	Result     string     `db:"result" json:"result,omitempty"`
+	Error      string     `db:"error" json:"error,omitempty"`
+}
+
+// CreateRelayTimer stores t, replacing the relay's active timer if it has one.
+func CreateRelayTimer(ctx context.Context, t *RelayTimer) error {
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE relay_timers SET finished_at = ?, result = 'replaced' WHERE relay = ? AND finished_at IS NULL`,
+		now, t.Relay); err != nil {
+		return err
+	}
+	t.CreatedAt, t.EndsAt = now, t.EndsAt.UTC()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO relay_timers (relay, end_state, ends_at, created_by, created_at) VALUES (?, ?, ?, ?, ?)`,
+		t.Relay, t.EndState, t.EndsAt, t.CreatedBy, t.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func GetRelayTimer(ctx context.Context, id int64) (*RelayTimer, error) {
+	var t RelayTimer
+	if err := DB.GetContext(ctx, &t, `SELECT * FROM relay_timers WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &t, nil
+}
+
+// ListActiveRelayTimers lists the timers that haven't ended, soonest first.
+func ListActiveRelayTimers(ctx context.Context) ([]RelayTimer, error) {
+	var out []RelayTimer
+	err := DB.SelectContext(ctx, &out, `SELECT * FROM relay_timers WHERE finished_at IS NULL ORDER BY ends_at`)
+	return out, err
+}
+
+// FinishRelayTimer ends an active timer with result. It reports false if the
+// timer had already ended, so a cancel and the timer firing can't both win.
+func FinishRelayTimer(ctx context.Context, id int64, result, errMsg string) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		`UPDATE relay_timers SET finished_at = ?, result = ?, error = ? WHERE id = ? AND finished_at IS NULL`,
+		time.Now().UTC(), result, errMsg, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetRelayTimerResult records how an ended timer's end action went.
+func SetRelayTimerResult(ctx context.Context, id int64, result, errMsg string) error {
+	_, err := DB.ExecContext(ctx, `UPDATE relay_timers SET result = ?, error = ? WHERE id = ?`, result, errMsg, id)
+	return err
+}
+
+// PruneRelayTimers deletes timers that ended before t.
+func PruneRelayTimers(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM relay_timers WHERE finished_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
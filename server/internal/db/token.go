@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// APIToken is a long-lived bearer token for scripts and integrations. Only
+// the hash of the secret is stored.
+type APIToken struct {
+	ID         int64      `db:"id" json:"id"`
+	Name       string     `db:"name" json:"name"`
+	TokenHash  string     `db:"token_hash" json:"-"`
+	Scopes     string     `db:"scopes" json:"-"`
+	UserID     *int64     `db:"user_id" json:"user_id"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+// ScopeList returns the token's scopes; they're stored space-separated.
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// CreateAPIToken stores a token; userID is nil for tokens made from the CLI.
+func CreateAPIToken(ctx context.Context, name, tokenHash string, scopes []string, userID *int64) (*APIToken, error) {
+	t := APIToken{
+		Name:      name,
+		TokenHash: tokenHash,
+		Scopes:    strings.Join(scopes, " "),
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+	}
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO api_tokens (name, token_hash, scopes, user_id, created_at) VALUES (?, ?, ?, ?, ?)`,
+		t.Name, t.TokenHash, t.Scopes, t.UserID, t.CreatedAt)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	var t APIToken
+	if err := DB.GetContext(ctx, &t, `SELECT * FROM api_tokens WHERE token_hash = ?`, tokenHash); err != nil {
+		return nil, dbErr(err)
+	}
+	return &t, nil
+}
+
+func GetAPIToken(ctx context.Context, id int64) (*APIToken, error) {
+	var t APIToken
+	if err := DB.GetContext(ctx, &t, `SELECT * FROM api_tokens WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &t, nil
+}
+
+func ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	var tokens []APIToken
+	if err := DB.SelectContext(ctx, &tokens, `SELECT * FROM api_tokens ORDER BY id`); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// TouchAPIToken records that the token was just used.
+func TouchAPIToken(ctx context.Context, id int64, at time.Time) error {
+	_, err := DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, at.UTC(), id)
+	return err
+}
+
+func DeleteAPIToken(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
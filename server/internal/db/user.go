@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type User struct {
+	ID           int64     `db:"id" json:"id"`
+	Username     string    `db:"username" json:"username"`
+	PasswordHash string    `db:"password_hash" json:"-"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	Role         string    `db:"role" json:"role"`
+}
+
+type Session struct {
+	TokenHash string    `db:"token_hash"`
+	UserID    int64     `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+func CreateUser(ctx context.Context, username, passwordHash, role string) (*User, error) {
+	u := User{Username: username, PasswordHash: passwordHash, CreatedAt: time.Now().UTC(), Role: role}
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO users (username, password_hash, created_at, role) VALUES (?, ?, ?, ?)`,
+		u.Username, u.PasswordHash, u.CreatedAt, u.Role)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	if u.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func GetUserByName(ctx context.Context, username string) (*User, error) {
+	var u User
+	if err := DB.GetContext(ctx, &u, `SELECT * FROM users WHERE username = ?`, username); err != nil {
+		return nil, dbErr(err)
+	}
+	return &u, nil
+}
+
+func GetUser(ctx context.Context, id int64) (*User, error) {
+	var u User
+	if err := DB.GetContext(ctx, &u, `SELECT * FROM users WHERE id = ?`, id); err != nil {
+		return nil, dbErr(err)
+	}
+	return &u, nil
+}
+
+func ListUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	if err := DB.SelectContext(ctx, &users, `SELECT * FROM users ORDER BY username`); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := DB.GetContext(ctx, &n, `SELECT COUNT(*) FROM users`)
+	return n, err
+}
+
+func UpdateUserPassword(ctx context.Context, id int64, passwordHash string) error {
+	_, err := DB.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	return err
+}
+
+func UpdateUserRole(ctx context.Context, id int64, role string) error {
+	_, err := DB.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, id)
+	return err
+}
+
+// ListUserPermissions returns the scopes granted to a user on top of their role.
+func ListUserPermissions(ctx context.Context, id int64) ([]string, error) {
+	var scopes []string
+	if err := DB.SelectContext(ctx, &scopes,
+		`SELECT scope FROM user_permissions WHERE user_id = ? ORDER BY scope`, id); err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// SetUserPermissions replaces all of a user's granted scopes.
+func SetUserPermissions(ctx context.Context, id int64, scopes []string) error {
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_permissions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	for _, s := range scopes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO user_permissions (user_id, scope) VALUES (?, ?)`, id, s); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GrantUserPermissions(ctx context.Context, id int64, scopes []string) error {
+	for _, s := range scopes {
+		if _, err := DB.ExecContext(ctx,
+			`INSERT OR IGNORE INTO user_permissions (user_id, scope) VALUES (?, ?)`, id, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RevokeUserPermissions(ctx context.Context, id int64, scopes []string) error {
+	for _, s := range scopes {
+		if _, err := DB.ExecContext(ctx,
+			`DELETE FROM user_permissions WHERE user_id = ? AND scope = ?`, id, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUser removes a user together with their sessions, API tokens and permissions.
+func DeleteUser(ctx context.Context, id int64) error {
+	tx, err := DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_permissions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func CreateSession(ctx context.Context, tokenHash string, userID int64, ttl time.Duration) (*Session, error) {
+	now := time.Now().UTC()
+	s := Session{TokenHash: tokenHash, UserID: userID, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		s.TokenHash, s.UserID, s.CreatedAt, s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSessionUser returns the user behind an unexpired session.
+func GetSessionUser(ctx context.Context, tokenHash string) (*User, error) {
+	var u User
+	err := DB.GetContext(ctx, &u, `
+		SELECT u.* FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`, tokenHash, time.Now().UTC())
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	return &u, nil
+}
+
+func DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := DB.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func DeleteUserSessions(ctx context.Context, userID int64) error {
+	_, err := DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+func PruneSessions(ctx context.Context) (int64, error) {
+	res, err := DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
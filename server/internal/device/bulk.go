@@ -0,0 +1,76 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetRelaysTimeout is how long SetRelays waits for the board to report the
+// new states.
+const SetRelaysTimeout = 3 * time.Second
+
+// RelayMask returns the last reported states as a bitmask; bit n-1 is relay n.
+func (m *Manager) RelayMask() byte {
+	m.relayStatesM.RLock()
+	defer m.relayStatesM.RUnlock()
+	return m.relayMaskLocked()
+}
+
+func (m *Manager) relayMaskLocked() byte {
+	var mask byte
+	for i, v := range m.relayStates {
+		mask |= byte(v) << i
+	}
+	return mask
+}
+
+// SetRelays switches the relays selected by which to their bits in mask, with
+// a single "SET:<mask>:<which>" command covering only the relays that aren't
+// already there. It waits for the board to confirm and returns the reported
+// bitmask.
+func (m *Manager) SetRelays(ctx context.Context, mask, which byte, o Origin) (byte, error) {
+	m.relayStatesM.Lock()
+	current := m.relayMaskLocked()
+	change := (current ^ mask) & which
+	if change == 0 {
+		m.relayStatesM.Unlock()
+		return current, nil
+	}
+	reports := make(chan byte, 8)
+	m.maskWaiters[reports] = struct{}{}
+	m.relayStatesM.Unlock()
+	defer func() {
+		m.relayStatesM.Lock()
+		delete(m.maskWaiters, reports)
+		m.relayStatesM.Unlock()
+	}()
+
+	m.noteOrigin(change, o)
+	if err := m.write("relays", []byte(fmt.Sprintf("SET:%02X:%02X\n", mask&change, change))); err != nil {
+		return current, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, SetRelaysTimeout)
+	defer cancel()
+	for {
+		select {
+		case got := <-reports:
+			if got&change == mask&change {
+				return got, nil
+			}
+		case <-ctx.Done():
+			return m.RelayMask(), ErrNotConfirmed
+		}
+	}
+}
+
+// notifyMaskLocked hands a reported bitmask to SetRelays calls waiting on it.
+func (m *Manager) notifyMaskLocked(mask byte) {
+	for ch := range m.maskWaiters {
+		select {
+		case ch <- mask:
+		default:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package device
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Origin says what asked for a relay change, for the relay history.
+type Origin struct {
+	Command string // e.g. "relay.toggle"
+	Actor   string // e.g. "alice" or "token:3"
+}
+
+// RelayChange is a reported bitmask that differs from the previous one.
+// Origin is empty when the change wasn't one we asked for (a button on the
+// board, a reboot, ...).
+type RelayChange struct {
+	Prev   byte
+	Mask   byte
+	At     time.Time
+	Origin Origin
+}
+
+// originWindow is how long after a command a reported change is attributed to it.
+const originWindow = 5 * time.Second
+
+type pendingOrigin struct {
+	Origin
+	at time.Time
+}
+
+// SetRelayHandler registers fn to be called with every relay bitmask change,
+// e.g. to persist it.
+func (m *Manager) SetRelayHandler(fn func(c RelayChange)) {
+	m.relayStatesM.Lock()
+	defer m.relayStatesM.Unlock()
+	m.onRelay = fn
+}
+
+// noteOrigin remembers who asked for the relays in mask to change until the
+// board reports them.
+func (m *Manager) noteOrigin(mask byte, o Origin) {
+	if o == (Origin{}) {
+		return
+	}
+	p := pendingOrigin{Origin: o, at: time.Now()}
+	m.relayStatesM.Lock()
+	defer m.relayStatesM.Unlock()
+	for i := range m.pending {
+		if mask&(1<<i) != 0 {
+			m.pending[i] = p
+		}
+	}
+}
+
+// takeOriginLocked returns and clears the origin of the relays that changed.
+// Commands from different callers landing in one report are joined.
+func (m *Manager) takeOriginLocked(changed byte, now time.Time) Origin {
+	var cmds, actors []string
+	for i := range m.pending {
+		p := m.pending[i]
+		if changed&(1<<i) == 0 || p.at.IsZero() {
+			continue
+		}
+		m.pending[i] = pendingOrigin{}
+		if now.Sub(p.at) > originWindow {
+			continue
+		}
+		if !slices.Contains(cmds, p.Command) {
+			cmds = append(cmds, p.Command)
+		}
+		if !slices.Contains(actors, p.Actor) {
+			actors = append(actors, p.Actor)
+		}
+	}
+	return Origin{Command: strings.Join(cmds, ","), Actor: strings.Join(actors, ",")}
+}
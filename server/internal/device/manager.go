@@ -2,6 +2,7 @@ package device
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,6 +10,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"relaypanel/internal/events"
 )
 
 type RelayState struct {
@@ -17,8 +20,36 @@ type RelayState struct {
 }
 
 type DeviceState struct {
-	Name  string `json:"name"`
-	State string `json:"state"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
+}
+
+// ModemControl is implemented by serial ports that can drive the DTR/RTS lines
+// wired to the ESP32 auto-reset circuit.
+type ModemControl interface {
+	SetDTR(dtr bool) error
+	SetRTS(rts bool) error
+}
+
+// Errors returned by Manager commands. They're wrapped with the device name
+// where one applies, so match them with errors.Is.
+var (
+	ErrNotConnected     = errors.New("not connected")
+	ErrInvalidRelay     = errors.New("invalid relay id")
+	ErrUnknownDevice    = errors.New("unknown device")
+	ErrResetUnsupported = errors.New("not serial-attached; hardware reset unavailable")
+	ErrWriteFailed      = errors.New("write failed")
+	ErrNotConfirmed     = errors.New("relay board did not confirm the change")
+)
+
+// rebootGrace is how long after a reboot request a dropped connection is
+// treated as expected.
+const rebootGrace = time.Minute
+
+type reboot struct {
+	reason string
+	at     time.Time
 }
 
 type Manager struct {
@@ -28,18 +59,35 @@ type Manager struct {
 	relayStates  [8]int
 	relayLabels  [8]string
 	relayStatesM sync.RWMutex
+	pending      [8]pendingOrigin
+	onRelay      func(c RelayChange)
+	maskWaiters  map[chan byte]struct{}
 
 	deviceM sync.RWMutex
 
 	dialers      map[string]func() (io.ReadWriteCloser, error)
 	reconnectM   sync.Mutex
 	reconnecting map[string]bool
+	rebooting    map[string]reboot
+
+	telemetry   map[string]Telemetry
+	onTelemetry func(name string, t Telemetry)
+	telemetryM  sync.RWMutex
+
+	onSensor func(r SensorReading)
+	sensorsM sync.RWMutex
+
+	onEvent func(typ string, data any)
+	eventM  sync.RWMutex
 }
 
 func NewManager() *Manager {
 	return &Manager{
 		dialers:      make(map[string]func() (io.ReadWriteCloser, error)),
 		reconnecting: make(map[string]bool),
+		rebooting:    make(map[string]reboot),
+		telemetry:    make(map[string]Telemetry),
+		maskWaiters:  make(map[chan byte]struct{}),
 	}
 }
 
@@ -49,14 +97,40 @@ func (m *Manager) SetDialer(name string, d func() (io.ReadWriteCloser, error)) {
 	m.dialers[name] = d
 }
 
+// SetEventHandler registers fn to be called with every live state change
+// (see the events package for types), e.g. to publish it to an events.Hub.
+func (m *Manager) SetEventHandler(fn func(typ string, data any)) {
+	m.eventM.Lock()
+	defer m.eventM.Unlock()
+	m.onEvent = fn
+}
+
+func (m *Manager) emit(typ string, data any) {
+	m.eventM.RLock()
+	fn := m.onEvent
+	m.eventM.RUnlock()
+	if fn != nil {
+		fn(typ, data)
+	}
+}
+
 func (m *Manager) SetDevice(name string, d io.ReadWriteCloser) {
 	m.deviceM.Lock()
-	defer m.deviceM.Unlock()
+	var prev io.ReadWriteCloser
 	switch name {
 	case "relays":
-		m.relays = d
+		prev, m.relays = m.relays, d
 	case "buzzer":
-		m.buzzer = d
+		prev, m.buzzer = m.buzzer, d
+	}
+	m.deviceM.Unlock()
+
+	if (prev == nil) != (d == nil) {
+		state := "connected"
+		if d == nil {
+			state = "disconnected"
+		}
+		m.emit(events.TypeDevice, events.DeviceChange{Name: name, State: state})
 	}
 }
 
@@ -90,7 +164,15 @@ func (m *Manager) readFromDevice(deviceName string, dev io.ReadWriteCloser) {
 				time.Sleep(200 * time.Millisecond)
 				continue
 			}
-			slog.Error("device read error", "device", deviceName, "err", err)
+			m.reconnectM.Lock()
+			rb, expected := m.rebooting[deviceName]
+			m.reconnectM.Unlock()
+			expected = expected && time.Since(rb.at) < rebootGrace
+			if expected {
+				slog.Info("device disconnected for reboot", "device", deviceName, "reason", rb.reason)
+			} else {
+				slog.Error("device read error", "device", deviceName, "err", err)
+			}
 			_ = dev.Close()
 			m.SetDevice(deviceName, nil)
 			dial := func() (io.ReadWriteCloser, error) { return nil, fmt.Errorf("no dialer") }
@@ -108,8 +190,31 @@ func (m *Manager) readFromDevice(deviceName string, dev io.ReadWriteCloser) {
 			// slog.Info("heartbeat", "device", deviceName, "HB", line[3:])
 			continue
 		}
+		if strings.HasPrefix(line, "TELEM:") {
+			if err := m.handleTelemetry(deviceName, strings.TrimPrefix(line, "TELEM:")); err != nil {
+				slog.Error("invalid TELEM line", "device", deviceName, "line", line, "err", err)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "SENSOR:") {
+			if err := m.handleSensor(deviceName, strings.TrimPrefix(line, "SENSOR:")); err != nil {
+				slog.Error("invalid SENSOR line", "device", deviceName, "line", line, "err", err)
+			}
+			continue
+		}
 		slog.Info("read", "device", deviceName, "line", line)
 
+		// older doorbell firmware only prints the banner
+		if line == "RING" || strings.Contains(line, "Door is ringing") {
+			m.emit(events.TypeRing, events.Ring{Device: deviceName})
+			continue
+		}
+
+		if strings.HasPrefix(line, "BOOT:") {
+			m.handleBoot(deviceName, strings.TrimSpace(strings.TrimPrefix(line, "BOOT:")))
+			continue
+		}
+
 		if strings.HasPrefix(line, "RELAYS:") {
 			hexStr := strings.TrimSpace(strings.TrimPrefix(line, "RELAYS:"))
 			if strings.HasPrefix(hexStr, "0x") || strings.HasPrefix(hexStr, "0X") {
@@ -123,12 +228,26 @@ func (m *Manager) readFromDevice(deviceName string, dev io.ReadWriteCloser) {
 			b := byte(val)
 
 			m.relayStatesM.Lock()
+			prev := m.relayMaskLocked()
 			for i := 0; i < len(m.relayStates); i++ {
 				m.relayStates[i] = int((b >> i) & 1)
 			}
+			var change *RelayChange
+			if prev != b {
+				now := time.Now()
+				change = &RelayChange{Prev: prev, Mask: b, At: now, Origin: m.takeOriginLocked(prev^b, now)}
+			}
+			onRelay := m.onRelay
+			m.notifyMaskLocked(b)
 			m.relayStatesM.Unlock()
 
 			slog.Info("relay states updated", "device", deviceName, "bitmask", fmt.Sprintf("%08b", b))
+			if change != nil {
+				m.emit(events.TypeRelays, m.RelayStates())
+				if onRelay != nil {
+					onRelay(*change)
+				}
+			}
 			continue
 		}
 	}
@@ -172,6 +291,11 @@ func (m *Manager) startReconnectIfNeeded(name string, dial func() (io.ReadWriteC
 			}
 			m.SetDevice(name, dev)
 			slog.Info("device connected", "device", name, "attempt", attempt, "dur", dur)
+			m.reconnectM.Lock()
+			if rb, ok := m.rebooting[name]; ok {
+				slog.Info("device back after reboot", "device", name, "reason", rb.reason, "downtime", time.Since(rb.at))
+			}
+			m.reconnectM.Unlock()
 			go m.readFromDevice(name, dev)
 			return
 		}
@@ -192,11 +316,13 @@ func (m *Manager) RelayStates() []RelayState {
 }
 
 func (m *Manager) UpdateLabel(index int, label string) {
-	m.relayStatesM.Lock()
-	defer m.relayStatesM.Unlock()
-	if index >= 0 && index < len(m.relayLabels) {
-		m.relayLabels[index] = label
+	if index < 0 || index >= len(m.relayLabels) {
+		return
 	}
+	m.relayStatesM.Lock()
+	m.relayLabels[index] = label
+	m.relayStatesM.Unlock()
+	m.emit(events.TypeLabel, events.LabelChange{Relay: index + 1, Label: label})
 }
 
 func (m *Manager) SetLabels(labels [8]string) {
@@ -206,46 +332,121 @@ func (m *Manager) SetLabels(labels [8]string) {
 }
 
 func (m *Manager) ToggleRelay(id string) error {
+	return m.ToggleRelayAs(id, Origin{})
+}
+
+// ToggleRelayAs toggles a relay and credits the resulting change to o in the
+// relay history.
+func (m *Manager) ToggleRelayAs(id string, o Origin) error {
 	if len(id) != 1 || id[0] < '1' || id[0] > '8' {
-		return fmt.Errorf("invalid relay id")
+		return ErrInvalidRelay
 	}
-	d := m.GetDevice("relays")
+	m.noteOrigin(1<<(id[0]-'1'), o)
+	return m.write("relays", []byte(id))
+}
+
+func (m *Manager) BuzzDoor() error {
+	return m.write("buzzer", []byte("1"))
+}
+
+// write sends a command to a device, dropping the connection and scheduling a
+// reconnect if the write fails.
+func (m *Manager) write(name string, cmd []byte) error {
+	d := m.GetDevice(name)
 	if d == nil {
-		return fmt.Errorf("relays not connected")
+		return fmt.Errorf("%s %w", name, ErrNotConnected)
 	}
-	if _, err := d.Write([]byte(id)); err != nil {
+	if _, err := d.Write(cmd); err != nil {
 		_ = d.Close()
-		m.SetDevice("relays", nil)
-		slog.Warn("device write failed; scheduling reconnect", "device", "relays", "err", err)
+		m.SetDevice(name, nil)
+		slog.Warn("device write failed; scheduling reconnect", "device", name, "err", err)
 		m.reconnectM.Lock()
-		if dial, ok := m.dialers["relays"]; ok {
+		if dial, ok := m.dialers[name]; ok {
 			m.reconnectM.Unlock()
-			m.startReconnectIfNeeded("relays", dial)
+			m.startReconnectIfNeeded(name, dial)
 		} else {
 			m.reconnectM.Unlock()
 		}
-		return fmt.Errorf("write failed: %w", err)
+		return fmt.Errorf("%w: %w", ErrWriteFailed, err)
 	}
 	return nil
 }
 
-func (m *Manager) BuzzDoor() error {
-	d := m.GetDevice("buzzer")
-	if d == nil {
-		return fmt.Errorf("buzzer not connected")
+// Reboot asks the firmware to restart itself. The connection is dropped right
+// away so the reader goes through the normal reconnect path while the board boots.
+func (m *Manager) Reboot(name, reason string) error {
+	if name != "relays" && name != "buzzer" {
+		return fmt.Errorf("%w %q", ErrUnknownDevice, name)
+	}
+	m.reconnectM.Lock()
+	m.rebooting[name] = reboot{reason: reason, at: time.Now()}
+	m.reconnectM.Unlock()
+
+	if err := m.write(name, []byte("REBOOT\n")); err != nil {
+		m.clearReboot(name)
+		return err
 	}
-	if _, err := d.Write([]byte("1")); err != nil {
+	slog.Warn("device reboot requested", "device", name, "reason", reason)
+	if d := m.GetDevice(name); d != nil {
 		_ = d.Close()
-		m.SetDevice("buzzer", nil)
-		slog.Warn("device write failed; scheduling reconnect", "device", "buzzer", "err", err)
-		m.reconnectM.Lock()
-		if dial, ok := m.dialers["buzzer"]; ok {
-			m.reconnectM.Unlock()
-			m.startReconnectIfNeeded("buzzer", dial)
-		} else {
-			m.reconnectM.Unlock()
-		}
-		return fmt.Errorf("write failed: %w", err)
 	}
 	return nil
 }
+
+// HardReset pulses the ESP32 auto-reset circuit over DTR/RTS. Only serial
+// connections can do this; the port stays open across the reset.
+func (m *Manager) HardReset(name, reason string) error {
+	if name != "relays" && name != "buzzer" {
+		return fmt.Errorf("%w %q", ErrUnknownDevice, name)
+	}
+	d := m.GetDevice(name)
+	if d == nil {
+		return fmt.Errorf("%s %w", name, ErrNotConnected)
+	}
+	mc, ok := d.(ModemControl)
+	if !ok {
+		return fmt.Errorf("%s is %w", name, ErrResetUnsupported)
+	}
+
+	m.reconnectM.Lock()
+	m.rebooting[name] = reboot{reason: reason, at: time.Now()}
+	m.reconnectM.Unlock()
+
+	// EN is pulled low while RTS is asserted and DTR is not; keeping DTR
+	// released leaves IO0 high so the board boots normally.
+	if err := mc.SetDTR(false); err != nil {
+		m.clearReboot(name)
+		return fmt.Errorf("set DTR failed: %w", err)
+	}
+	if err := mc.SetRTS(true); err != nil {
+		m.clearReboot(name)
+		return fmt.Errorf("set RTS failed: %w", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := mc.SetRTS(false); err != nil {
+		m.clearReboot(name)
+		return fmt.Errorf("set RTS failed: %w", err)
+	}
+	slog.Warn("device hardware reset", "device", name, "reason", reason)
+	return nil
+}
+
+func (m *Manager) clearReboot(name string) {
+	m.reconnectM.Lock()
+	defer m.reconnectM.Unlock()
+	delete(m.rebooting, name)
+}
+
+// handleBoot logs the reset reason a board reports after coming up and closes
+// out any reboot we were waiting for.
+func (m *Manager) handleBoot(name, resetReason string) {
+	m.reconnectM.Lock()
+	rb, ok := m.rebooting[name]
+	delete(m.rebooting, name)
+	m.reconnectM.Unlock()
+	if ok {
+		slog.Info("device rebooted", "device", name, "reason", rb.reason, "reset_reason", resetReason, "downtime", time.Since(rb.at))
+		return
+	}
+	slog.Info("device booted", "device", name, "reset_reason", resetReason)
+}
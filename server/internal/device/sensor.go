@@ -0,0 +1,59 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SensorReading is a value a board reported for one of its inputs:
+//
+//	SENSOR:hall_temp=21.50 C
+//	SENSOR:front_door=1
+type SensorReading struct {
+	Device string    `json:"device"`
+	Name   string    `json:"name"`
+	Value  float64   `json:"value"`
+	Unit   string    `json:"unit,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+func parseSensor(device, s string, at time.Time) (SensorReading, error) {
+	r := SensorReading{Device: device, At: at}
+	name, rest, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return r, fmt.Errorf("missing sensor name")
+	}
+	r.Name = name
+	value, unit, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return r, fmt.Errorf("sensor %s: %w", name, err)
+	}
+	r.Value = v
+	r.Unit = strings.TrimSpace(unit)
+	return r, nil
+}
+
+// SetSensorHandler registers fn to be called with every sensor reading.
+func (m *Manager) SetSensorHandler(fn func(r SensorReading)) {
+	m.sensorsM.Lock()
+	defer m.sensorsM.Unlock()
+	m.onSensor = fn
+}
+
+func (m *Manager) handleSensor(device, payload string) error {
+	r, err := parseSensor(device, payload, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	m.sensorsM.RLock()
+	fn := m.onSensor
+	m.sensorsM.RUnlock()
+	if fn != nil {
+		fn(r)
+	}
+	return nil
+}
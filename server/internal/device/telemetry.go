@@ -0,0 +1,75 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Telemetry is a board health sample, reported as
+//
+//	TELEM:rssi=-61 uptime=3600 heap=201234
+type Telemetry struct {
+	RSSI     int       `json:"rssi"`
+	Uptime   int64     `json:"uptime_s"`
+	FreeHeap int64     `json:"free_heap"`
+	At       time.Time `json:"at"`
+}
+
+func parseTelemetry(s string, at time.Time) (Telemetry, error) {
+	t := Telemetry{At: at}
+	for _, field := range strings.Fields(s) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return t, fmt.Errorf("malformed field %q", field)
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return t, fmt.Errorf("field %s: %w", k, err)
+		}
+		switch k {
+		case "rssi":
+			t.RSSI = int(n)
+		case "uptime":
+			t.Uptime = n
+		case "heap":
+			t.FreeHeap = n
+		}
+	}
+	return t, nil
+}
+
+// SetTelemetryHandler registers fn to be called with every sample a device
+// reports, e.g. to persist it.
+func (m *Manager) SetTelemetryHandler(fn func(name string, t Telemetry)) {
+	m.telemetryM.Lock()
+	defer m.telemetryM.Unlock()
+	m.onTelemetry = fn
+}
+
+// Telemetry returns the latest sample for a device, or nil if none was seen yet.
+func (m *Manager) Telemetry(name string) *Telemetry {
+	m.telemetryM.RLock()
+	defer m.telemetryM.RUnlock()
+	t, ok := m.telemetry[name]
+	if !ok {
+		return nil
+	}
+	return &t
+}
+
+func (m *Manager) handleTelemetry(name, payload string) error {
+	t, err := parseTelemetry(payload, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	m.telemetryM.Lock()
+	m.telemetry[name] = t
+	fn := m.onTelemetry
+	m.telemetryM.Unlock()
+	if fn != nil {
+		fn(name, t)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package events fans live state changes out to subscribers such as the
+// /events SSE stream, keeping a short backlog so clients can resume.
+package events
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypeRelays = "relays" // data: []device.RelayState
+	TypeDevice = "device" // data: DeviceChange
+	TypeRing   = "ring"   // data: Ring
+	TypeLabel  = "label"  // data: LabelChange
+	TypeTV     = "tv"     // data: adb.TVState
+	TypeTimer  = "timer"  // data: TimerChange
+)
+
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Data any       `json:"data"`
+	At   time.Time `json:"at"`
+}
+
+type DeviceChange struct {
+	Name  string `json:"name"`
+	State string `json:"state"` // "connected" or "disconnected"
+}
+
+type Ring struct {
+	Device string `json:"device"`
+}
+
+type LabelChange struct {
+	Relay int    `json:"relay"`
+	Label string `json:"label"`
+}
+
+// TimerChange is a relay timer being started, replaced, cancelled or
+// ending. Timer is the relay's timer afterwards, nil if it has none.
+type TimerChange struct {
+	Relay int         `json:"relay"`
+	Timer *RelayTimer `json:"timer"`
+}
+
+type RelayTimer struct {
+	ID        int64     `json:"id"`
+	EndsAt    time.Time `json:"ends_at"`
+	EndAction string    `json:"end_action"` // "off" or "on"
+}
+
+// DefaultBacklog is how many events a Hub keeps for resuming clients.
+const DefaultBacklog = 256
+
+// subscriberBuffer is how far a subscriber may fall behind before it's dropped.
+const subscriberBuffer = 64
+
+type Hub struct {
+	// boot tells this run's event IDs from those of an earlier one, which
+	// also start at 1.
+	boot string
+
+	mu      sync.Mutex
+	nextID  uint64
+	backlog []Event
+	size    int
+	subs    map[chan Event]struct{}
+}
+
+func NewHub(backlog int) *Hub {
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	return &Hub{
+		boot:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		nextID: 1,
+		size:   backlog,
+		subs:   make(map[chan Event]struct{}),
+	}
+}
+
+// StreamID is e's id on a stream clients resume across restarts:
+// "<boot>-<id>".
+func (h *Hub) StreamID(e Event) string {
+	return h.boot + "-" + strconv.FormatUint(e.ID, 10)
+}
+
+// Resume is Subscribe for a StreamID a client last saw. An empty one starts
+// afresh; one from an earlier run of the server, or one that isn't a
+// StreamID at all, is incomplete and replays nothing.
+func (h *Hub) Resume(streamID string) (missed []Event, complete bool, ch <-chan Event, cancel func()) {
+	if streamID == "" {
+		return h.Subscribe(0)
+	}
+	boot, seq, _ := strings.Cut(streamID, "-")
+	lastID, err := strconv.ParseUint(seq, 10, 64)
+	if boot != h.boot || err != nil {
+		_, _, ch, cancel = h.Subscribe(0)
+		return nil, false, ch, cancel
+	}
+	return h.Subscribe(lastID)
+}
+
+// Publish assigns the event an ID and hands it to every subscriber. A
+// subscriber that can't keep up has its channel closed.
+func (h *Hub) Publish(typ string, data any) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := Event{ID: h.nextID, Type: typ, Data: data, At: time.Now().UTC()}
+	h.nextID++
+	h.backlog = append(h.backlog, e)
+	if len(h.backlog) > h.size {
+		h.backlog = h.backlog[len(h.backlog)-h.size:]
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// Subscribe returns the events after lastID that are still in the backlog
+// and a channel for new ones. complete is false when events after lastID
+// were already dropped (or lastID hasn't been handed out yet), in which case
+// the client should reload its state. cancel must be called when done.
+func (h *Hub) Subscribe(lastID uint64) (missed []Event, complete bool, ch <-chan Event, cancel func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	complete = true
+	if lastID > 0 {
+		switch {
+		case lastID >= h.nextID:
+			complete = false
+		case len(h.backlog) > 0 && h.backlog[0].ID > lastID+1:
+			complete = false
+		}
+		for _, e := range h.backlog {
+			if e.ID > lastID {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	c := make(chan Event, subscriberBuffer)
+	h.subs[c] = struct{}{}
+	return missed, complete, c, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[c]; ok {
+			delete(h.subs, c)
+			close(c)
+		}
+	}
+}
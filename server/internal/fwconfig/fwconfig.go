@@ -0,0 +1,83 @@
+package fwconfig
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// Config is everything a board's config.h carries.
+type Config struct {
+	Device       string
+	WiFiSSID     string
+	WiFiPassword string
+	OTAHostname  string
+	OTAPassword  string
+
+	// RelayPins are the physical header pins of relays 1..n, in order. Empty
+	// for boards without a relay map.
+	RelayPins []int
+}
+
+var configTmpl = template.Must(template.New("config.h").Funcs(template.FuncMap{
+	"quote":   cQuote,
+	"pinList": pinList,
+}).Parse(`#pragma once
+
+// Generated by hektor firmware-config for device "{{.Device}}". Do not edit;
+// regenerate instead so the server's device record stays in sync.
+
+#define WIFI_SSID {{quote .WiFiSSID}}
+#define WIFI_PASSWORD {{quote .WiFiPassword}}
+
+#define OTA_HOSTNAME {{quote .OTAHostname}}
+#define OTA_PASSWORD {{quote .OTAPassword}}
+{{- if .RelayPins}}
+
+// Relays 1-{{len .RelayPins}} in order; expands after the PHYSICAL_PIN_* table in the sketch.
+#define RELAY_PIN_MAP {{pinList .RelayPins}}
+{{- end}}
+`))
+
+// Render writes config.h for cfg.
+func Render(w io.Writer, cfg Config) error {
+	for _, c := range []struct{ name, v string }{
+		{"wifi ssid", cfg.WiFiSSID},
+		{"wifi password", cfg.WiFiPassword},
+		{"ota hostname", cfg.OTAHostname},
+		{"ota password", cfg.OTAPassword},
+	} {
+		if c.v == "" {
+			return fmt.Errorf("%s is empty", c.name)
+		}
+	}
+	return configTmpl.Execute(w, cfg)
+}
+
+// cQuote renders s as a C string literal.
+func cQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func pinList(pins []int) string {
+	parts := make([]string, len(pins))
+	for i, p := range pins {
+		parts[i] = fmt.Sprintf("PHYSICAL_PIN_%d", p)
+	}
+	return strings.Join(parts, ", ")
+}
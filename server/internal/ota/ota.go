@@ -0,0 +1,183 @@
+package ota
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArduinoOTA invite commands (see espota.py).
+const (
+	CommandFlash  = 0
+	CommandSPIFFS = 100
+	CommandAuth   = 200
+)
+
+const (
+	DefaultPort = 3232
+
+	chunkSize     = 1024
+	inviteTimeout = 10 * time.Second
+	inviteRetries = 3
+	acceptTimeout = 10 * time.Second
+	ackTimeout    = 10 * time.Second
+	finishTimeout = 60 * time.Second
+)
+
+// ErrAuthFailed is returned when the board rejects the OTA password.
+var ErrAuthFailed = errors.New("ota authentication failed")
+
+// Target is an ArduinoOTA endpoint on a board.
+type Target struct {
+	Host     string
+	Port     int
+	Password string
+
+	// PreviousPassword is tried if Password is rejected, for boards still
+	// running firmware built before a password rotation.
+	PreviousPassword string
+}
+
+func (t Target) addr() string {
+	port := t.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(t.Host, strconv.Itoa(port))
+}
+
+// Progress is called after every chunk the board acknowledges.
+type Progress func(sent, total int)
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Upload pushes image to the target using the ArduinoOTA network protocol:
+// UDP invite (with optional challenge auth), then the board connects back over
+// TCP and receives the image in chunks, acknowledging each one.
+func Upload(ctx context.Context, t Target, name string, image []byte, progress Progress) error {
+	ln, err := net.ListenTCP("tcp4", &net.TCPAddr{})
+	if err != nil {
+		return fmt.Errorf("ota listen failed: %w", err)
+	}
+	defer ln.Close()
+	localPort := ln.Addr().(*net.TCPAddr).Port
+
+	udp, err := net.Dial("udp4", t.addr())
+	if err != nil {
+		return fmt.Errorf("ota dial failed: %w", err)
+	}
+	defer udp.Close()
+
+	imageMD5 := md5Hex(image)
+	invite := fmt.Sprintf("%d %d %d %s\n", CommandFlash, localPort, len(image), imageMD5)
+	slog.Info("ota invite", "target", t.addr(), "size", len(image), "md5", imageMD5)
+	resp, err := exchange(ctx, udp, invite)
+	if err != nil {
+		return fmt.Errorf("ota invite failed: %w", err)
+	}
+
+	if nonce, ok := strings.CutPrefix(resp, "AUTH "); ok {
+		if t.Password == "" {
+			return fmt.Errorf("ota target requires a password")
+		}
+		remote := udp.RemoteAddr().(*net.UDPAddr).IP.String()
+		cnonce := md5Hex([]byte(fmt.Sprintf("%s%d%s%s", name, len(image), imageMD5, remote)))
+		result := md5Hex([]byte(md5Hex([]byte(t.Password)) + ":" + strings.TrimSpace(nonce) + ":" + cnonce))
+		resp, err = exchange(ctx, udp, fmt.Sprintf("%d %s %s\n", CommandAuth, cnonce, result))
+		if err != nil {
+			return fmt.Errorf("ota auth failed: %w", err)
+		}
+		if resp != "OK" {
+			return fmt.Errorf("%w: %q", ErrAuthFailed, resp)
+		}
+	}
+	if resp != "OK" {
+		return fmt.Errorf("ota rejected: %q", resp)
+	}
+
+	_ = ln.SetDeadline(time.Now().Add(acceptTimeout))
+	conn, err := ln.Accept()
+	if err != nil {
+		return fmt.Errorf("ota board did not connect back: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	ack := make([]byte, 32)
+	var tail bytes.Buffer
+	sent := 0
+	for sent < len(image) {
+		end := min(sent+chunkSize, len(image))
+		if _, err := conn.Write(image[sent:end]); err != nil {
+			return fmt.Errorf("ota transfer failed at %d/%d: %w", sent, len(image), err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(ackTimeout))
+		n, err := conn.Read(ack)
+		if err != nil {
+			return fmt.Errorf("ota ack failed at %d/%d: %w", sent, len(image), err)
+		}
+		sent = end
+		if sent == len(image) {
+			tail.Write(ack[:n])
+		}
+		if progress != nil {
+			progress(sent, len(image))
+		}
+	}
+
+	// The board flushes, verifies the MD5 and answers "OK" before rebooting.
+	// Trailing chunk acks may arrive first.
+	_ = conn.SetReadDeadline(time.Now().Add(finishTimeout))
+	for {
+		if bytes.Contains(tail.Bytes(), []byte("OK")) {
+			slog.Info("ota complete", "target", t.addr(), "size", len(image))
+			return nil
+		}
+		if bytes.Contains(tail.Bytes(), []byte("E")) {
+			return fmt.Errorf("ota board reported error: %q", tail.String())
+		}
+		n, err := conn.Read(ack)
+		tail.Write(ack[:n])
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("ota connection closed before confirmation")
+			}
+			return fmt.Errorf("ota confirmation failed: %w", err)
+		}
+	}
+}
+
+// exchange sends msg over UDP and waits for a single reply, retrying a few times.
+func exchange(ctx context.Context, c net.Conn, msg string) (string, error) {
+	buf := make([]byte, 128)
+	var lastErr error
+	for i := 0; i < inviteRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if _, err := c.Write([]byte(msg)); err != nil {
+			return "", err
+		}
+		_ = c.SetReadDeadline(time.Now().Add(inviteTimeout))
+		n, err := c.Read(buf)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return strings.TrimSpace(string(buf[:n])), nil
+	}
+	return "", fmt.Errorf("no response after %d attempts: %w", inviteRetries, lastErr)
+}
@@ -0,0 +1,160 @@
+package ota
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver is a Receiver serving on a loopback port for one test.
+type receiver struct {
+	target Target
+	images chan []byte
+	data   atomic.Int64 // image bytes read, whole image or not
+	stop   func()       // stops the receiver and waits for it
+}
+
+// startReceiver serves a Receiver on a free loopback port until the test
+// ends.
+func startReceiver(t *testing.T, password string) *receiver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv := &receiver{
+		target: Target{Host: "127.0.0.1", Port: pc.LocalAddr().(*net.UDPAddr).Port, Password: password},
+		images: make(chan []byte, 1),
+	}
+	r := &Receiver{
+		Password: password,
+		OnImage:  func(image []byte) { rv.images <- image },
+		OnData:   func(n int) { rv.data.Add(int64(n)) },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- r.Serve(ctx, pc) }()
+	rv.stop = sync.OnceFunc(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("receiver: %v", err)
+		}
+	})
+	t.Cleanup(rv.stop)
+	return rv
+}
+
+func testImage(size int) []byte {
+	image := make([]byte, size)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range image {
+		image[i] = byte(rng.Uint32())
+	}
+	return image
+}
+
+func TestUpload(t *testing.T) {
+	rv := startReceiver(t, "hunter2")
+	image := testImage(3*chunkSize + 100)
+
+	var acked []int
+	err := Upload(context.Background(), rv.target, "firmware.bin", image, func(sent, total int) {
+		if total != len(image) {
+			t.Errorf("progress total = %d, want %d", total, len(image))
+		}
+		acked = append(acked, sent)
+	})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	want := []int{chunkSize, 2 * chunkSize, 3 * chunkSize, len(image)}
+	if len(acked) != len(want) {
+		t.Fatalf("acked chunks = %v, want %v", acked, want)
+	}
+	for i := range want {
+		if acked[i] != want[i] {
+			t.Fatalf("acked chunks = %v, want %v", acked, want)
+		}
+	}
+	select {
+	case got := <-rv.images:
+		if !bytes.Equal(got, image) {
+			t.Fatal("receiver got a different image")
+		}
+		if n := rv.data.Load(); n != int64(len(image)) {
+			t.Fatalf("receiver read %d image bytes, want %d", n, len(image))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver did not report the image")
+	}
+}
+
+func TestUploadWrongPassword(t *testing.T) {
+	rv := startReceiver(t, "hunter2")
+	target := rv.target
+	target.Password = "hunter3"
+
+	err := Upload(context.Background(), target, "firmware.bin", testImage(100), nil)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Upload error = %v, want ErrAuthFailed", err)
+	}
+	rv.stop()
+	if n := rv.data.Load(); n != 0 {
+		t.Fatalf("receiver read %d image bytes after failed auth", n)
+	}
+}
+
+func TestUpdaterPasswordFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		boardHas     string
+		password     string
+		previous     string
+		wantState    string
+		wantFallback bool
+	}{
+		{"current password", "new", "new", "old", StateDone, false},
+		{"previous password", "old", "new", "old", StateDone, true},
+		{"no previous password", "old", "new", "", StateFailed, false},
+		{"neither password", "other", "new", "old", StateFailed, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := startReceiver(t, tt.boardHas).target
+			target.Password, target.PreviousPassword = tt.password, tt.previous
+
+			u := NewUpdater(t.TempDir())
+			path, _, err := u.SaveImage(testImage(2*chunkSize + 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan *Job, 1)
+			if _, err := u.Start(int64(i+1), "board", "firmware.bin", path, target, func(j *Job) { done <- j }); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+
+			var job *Job
+			select {
+			case job = <-done:
+			case <-time.After(30 * time.Second):
+				t.Fatal("update did not finish")
+			}
+			if job.State != tt.wantState {
+				t.Fatalf("state = %q (error %q), want %q", job.State, job.Error, tt.wantState)
+			}
+			if job.PasswordFallback != tt.wantFallback {
+				t.Errorf("password fallback = %v, want %v", job.PasswordFallback, tt.wantFallback)
+			}
+			if tt.wantState == StateDone && job.Sent != job.Total {
+				t.Errorf("sent %d of %d bytes", job.Sent, job.Total)
+			}
+		})
+	}
+}
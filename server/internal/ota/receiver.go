@@ -0,0 +1,163 @@
+package ota
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Receiver is a local stand-in for the ArduinoOTA side of a board. It answers
+// invites, checks auth the way the ESP32 core does and reads the image back over
+// TCP, so uploads can be exercised without flashing real hardware.
+type Receiver struct {
+	Addr     string // UDP listen address, e.g. "127.0.0.1:3232"
+	Password string
+
+	// OnImage is called with every image that was received and passed the MD5 check.
+	OnImage func(image []byte)
+
+	// OnData, if set, is called with the size of every chunk read from the
+	// uploader, whether or not the image turns out whole.
+	OnData func(n int)
+}
+
+// ListenAndServe listens on Addr and handles invites until ctx is cancelled.
+func (r *Receiver) ListenAndServe(ctx context.Context) error {
+	pc, err := net.ListenPacket("udp4", r.Addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(ctx, pc)
+}
+
+// Serve handles invites arriving on pc until ctx is cancelled, and closes pc.
+func (r *Receiver) Serve(ctx context.Context, pc net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { _ = pc.Close() })
+	defer stop()
+	defer pc.Close()
+	slog.Info("ota receiver listening", "addr", pc.LocalAddr().String())
+
+	buf := make([]byte, 256)
+	var (
+		pending *invite
+		nonce   string
+	)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		msg := strings.TrimSpace(string(buf[:n]))
+
+		if pending != nil && strings.HasPrefix(msg, strconv.Itoa(CommandAuth)+" ") {
+			inv := pending
+			pending = nil
+			fields := strings.Fields(msg)
+			if len(fields) != 3 {
+				_, _ = pc.WriteTo([]byte("Authentication Failed"), from)
+				continue
+			}
+			want := md5Hex([]byte(md5Hex([]byte(r.Password)) + ":" + nonce + ":" + fields[1]))
+			if fields[2] != want {
+				slog.Warn("ota receiver auth failed", "from", from.String())
+				_, _ = pc.WriteTo([]byte("Authentication Failed"), from)
+				continue
+			}
+			_, _ = pc.WriteTo([]byte("OK"), from)
+			r.receive(ctx, from, inv)
+			continue
+		}
+
+		inv, err := parseInvite(msg)
+		if err != nil {
+			slog.Warn("ota receiver bad invite", "from", from.String(), "msg", msg, "err", err)
+			continue
+		}
+		if r.Password != "" {
+			nonce = md5Hex([]byte(strconv.FormatInt(time.Now().UnixNano(), 10) + rand.Text()))
+			pending = inv
+			_, _ = pc.WriteTo([]byte("AUTH "+nonce), from)
+			continue
+		}
+		_, _ = pc.WriteTo([]byte("OK"), from)
+		r.receive(ctx, from, inv)
+	}
+}
+
+type invite struct {
+	command int
+	port    int
+	size    int
+	md5     string
+}
+
+func parseInvite(msg string) (*invite, error) {
+	fields := strings.Fields(msg)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("expected 4 fields, got %d", len(fields))
+	}
+	var inv invite
+	var err error
+	if inv.command, err = strconv.Atoi(fields[0]); err != nil {
+		return nil, err
+	}
+	if inv.port, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, err
+	}
+	if inv.size, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, err
+	}
+	inv.md5 = fields[3]
+	return &inv, nil
+}
+
+func (r *Receiver) receive(ctx context.Context, from net.Addr, inv *invite) {
+	host, _, _ := net.SplitHostPort(from.String())
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp4", net.JoinHostPort(host, strconv.Itoa(inv.port)))
+	if err != nil {
+		slog.Error("ota receiver connect back failed", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	image := make([]byte, 0, inv.size)
+	chunk := make([]byte, chunkSize)
+	for len(image) < inv.size {
+		_ = conn.SetReadDeadline(time.Now().Add(ackTimeout))
+		n, err := conn.Read(chunk)
+		if n > 0 {
+			if r.OnData != nil {
+				r.OnData(n)
+			}
+			image = append(image, chunk[:n]...)
+			_, _ = conn.Write([]byte(strconv.Itoa(n)))
+		}
+		if err != nil {
+			if err == io.EOF && len(image) == inv.size {
+				break
+			}
+			slog.Error("ota receiver read failed", "received", len(image), "size", inv.size, "err", err)
+			return
+		}
+	}
+	if got := md5Hex(image); got != inv.md5 {
+		slog.Error("ota receiver md5 mismatch", "got", got, "want", inv.md5)
+		_, _ = conn.Write([]byte("ERROR: md5 mismatch"))
+		return
+	}
+	slog.Info("ota receiver got image", "size", len(image), "md5", inv.md5)
+	_, _ = conn.Write([]byte("OK"))
+	if r.OnImage != nil {
+		r.OnImage(image)
+	}
+}
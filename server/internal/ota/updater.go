@@ -0,0 +1,175 @@
+package ota
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatePending   = "pending"
+	StateUploading = "uploading"
+	StateDone      = "done"
+	StateFailed    = "failed"
+
+	uploadTimeout = 5 * time.Minute
+)
+
+// Job is the live progress of one upload.
+type Job struct {
+	ID       int64  `json:"id"`
+	Device   string `json:"device"`
+	Firmware string `json:"firmware"`
+	State    string `json:"state"`
+	Sent     int    `json:"sent"`
+	Total    int    `json:"total"`
+	Error    string `json:"error,omitempty"`
+
+	// PasswordFallback is set when the board only accepted the previous password.
+	PasswordFallback bool       `json:"password_fallback,omitempty"`
+	StartedAt        time.Time  `json:"started_at"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+}
+
+// Updater runs uploads in the background and keeps their progress in memory.
+type Updater struct {
+	Dir string // where firmware images are stored
+
+	mu     sync.RWMutex
+	jobs   map[int64]*Job
+	active map[string]int64 // device -> running job id
+}
+
+func NewUpdater(dir string) *Updater {
+	return &Updater{
+		Dir:    dir,
+		jobs:   make(map[int64]*Job),
+		active: make(map[string]int64),
+	}
+}
+
+// SaveImage writes image into the firmware directory and returns its path and MD5.
+func (u *Updater) SaveImage(image []byte) (path, sum string, err error) {
+	if err := os.MkdirAll(u.Dir, 0o755); err != nil {
+		return "", "", err
+	}
+	h := md5.Sum(image)
+	sum = hex.EncodeToString(h[:])
+	path = filepath.Join(u.Dir, sum+".bin")
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		return "", "", err
+	}
+	return path, sum, nil
+}
+
+// Start uploads the image at path to t in the background. done is called once
+// with the finished job so the caller can record it.
+func (u *Updater) Start(id int64, device, firmware, path string, t Target, done func(*Job)) (*Job, error) {
+	image, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read firmware: %w", err)
+	}
+
+	u.mu.Lock()
+	if running, ok := u.active[device]; ok {
+		u.mu.Unlock()
+		return nil, fmt.Errorf("update %d already running for %s", running, device)
+	}
+	job := &Job{
+		ID:        id,
+		Device:    device,
+		Firmware:  firmware,
+		State:     StatePending,
+		Total:     len(image),
+		StartedAt: time.Now().UTC(),
+	}
+	u.jobs[id] = job
+	u.active[device] = id
+	u.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+		defer cancel()
+
+		u.update(id, func(j *Job) { j.State = StateUploading })
+		slog.Info("ota update started", "device", device, "firmware", firmware, "job", id)
+		progress := func(sent, total int) {
+			u.update(id, func(j *Job) { j.Sent = sent })
+		}
+		err := Upload(ctx, t, firmware, image, progress)
+		if errors.Is(err, ErrAuthFailed) && t.PreviousPassword != "" {
+			slog.Warn("ota password rejected; retrying with previous password", "device", device, "job", id)
+			prev := t
+			prev.Password = t.PreviousPassword
+			if err = Upload(ctx, prev, firmware, image, progress); err == nil {
+				u.update(id, func(j *Job) { j.PasswordFallback = true })
+			}
+		}
+		u.update(id, func(j *Job) {
+			now := time.Now().UTC()
+			j.FinishedAt = &now
+			if err != nil {
+				j.State = StateFailed
+				j.Error = err.Error()
+			} else {
+				j.State = StateDone
+			}
+		})
+
+		u.mu.Lock()
+		delete(u.active, device)
+		final := *u.jobs[id]
+		u.mu.Unlock()
+
+		if err != nil {
+			slog.Error("ota update failed", "device", device, "firmware", firmware, "job", id, "err", err)
+		} else {
+			slog.Info("ota update finished", "device", device, "firmware", firmware, "job", id)
+		}
+		if done != nil {
+			done(&final)
+		}
+	}()
+
+	return u.Job(id), nil
+}
+
+func (u *Updater) update(id int64, fn func(*Job)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if j, ok := u.jobs[id]; ok {
+		fn(j)
+	}
+}
+
+// Job returns a snapshot of a job, or nil if it isn't known.
+func (u *Updater) Job(id int64) *Job {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	j, ok := u.jobs[id]
+	if !ok {
+		return nil
+	}
+	cp := *j
+	return &cp
+}
+
+// Jobs returns snapshots of all jobs started since the server came up.
+func (u *Updater) Jobs() []Job {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	jobs := make([]Job, 0, len(u.jobs))
+	for _, j := range u.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID > jobs[k].ID })
+	return jobs
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	maxAuditExport    = 100000
+)
+
+// audit records a relay, door, TV or label operation made by the request's
+// caller; err is how the operation went.
+func (a *API) audit(r *http.Request, action, target string, params any, err error) {
+	recordAudit(context.WithoutCancel(r.Context()), auditEntry(r), action, target, params, err)
+}
+
+// auditEntry is who made r, for an audit entry recorded now or once a
+// background operation it started has finished.
+func auditEntry(r *http.Request) db.AuditEntry {
+	e := db.AuditEntry{
+		Actor:     actor(r),
+		ActorKind: db.ActorUser,
+		IP:        clientIP(r),
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	if auth.TokenFrom(r.Context()) != nil {
+		e.ActorKind = db.ActorToken
+	}
+	return e
+}
+
+// recordAudit fills in the operation and its outcome and stores e. Failing to
+// audit is logged but never fails the operation itself.
+func recordAudit(ctx context.Context, e db.AuditEntry, action, target string, params any, err error) {
+	e.Action, e.Target, e.Result = action, target, "ok"
+	if params != nil {
+		if b, jerr := json.Marshal(params); jerr == nil {
+			e.Params = string(b)
+		}
+	}
+	if err != nil {
+		_, e.Result = errorStatus(err)
+		e.Error = err.Error()
+	}
+	if ierr := db.InsertAuditEntry(ctx, e); ierr != nil {
+		slog.Error("failed to write audit log", "action", action, "target", target, "err", ierr)
+	}
+}
+
+// listAuditHandler serves GET /audit. Filters: actor, action, target, result
+// ("ok", "failed" or an error code), from, to. Entries come newest first;
+// pass the last ID seen as ?before= for the next page. ?format=csv exports
+// every matching entry instead.
+func (a *API) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := db.AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+		Result: q.Get("result"),
+		Limit:  defaultAuditLimit,
+	}
+	var err error
+	if s := q.Get("from"); s != "" {
+		if f.From, err = time.Parse(time.RFC3339, s); err != nil {
+			httpError(w, r, http.StatusBadRequest, "invalid from")
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		if f.To, err = time.Parse(time.RFC3339, s); err != nil {
+			httpError(w, r, http.StatusBadRequest, "invalid to")
+			return
+		}
+	}
+	if s := q.Get("before"); s != "" {
+		if f.Before, err = strconv.ParseInt(s, 10, 64); err != nil || f.Before <= 0 {
+			httpError(w, r, http.StatusBadRequest, "invalid before")
+			return
+		}
+	}
+	csvExport := q.Get("format") == "csv"
+	if csvExport {
+		f.Limit = maxAuditExport
+	}
+	if s := q.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit <= 0 {
+			httpError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if !csvExport {
+			f.Limit = min(f.Limit, maxAuditLimit)
+		}
+	}
+
+	entries, err := db.ListAuditEntries(r.Context(), f)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list audit log")
+		return
+	}
+	if csvExport {
+		writeAuditCSV(w, entries)
+		return
+	}
+	if entries == nil {
+		entries = []db.AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func writeAuditCSV(w http.ResponseWriter, entries []db.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("2006-01-02")+`.csv"`)
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "at", "actor", "actor_kind", "ip", "action", "target", "params", "result", "error", "request_id"})
+	for _, e := range entries {
+		_ = cw.Write([]string{
+			strconv.FormatInt(e.ID, 10), e.At.UTC().Format(time.RFC3339), e.Actor, e.ActorKind, e.IP,
+			e.Action, e.Target, e.Params, e.Result, e.Error, e.RequestID,
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,212 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const DefaultSessionTTL = 14 * 24 * time.Hour
+
+// isPublic reports whether a request is reachable without logging in: the
+// login page, guest links (which carry their own signature) and static
+// assets other than the panel.
+func isPublic(r *http.Request) bool {
+	p := r.URL.Path
+	if p == "/login" || p == "/login.html" || strings.HasPrefix(p, "/guest/") {
+		return true
+	}
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && isAsset(p)
+}
+
+// isAsset reports whether p names a file under staticDir() that isn't a
+// page. Only such paths fall through to the file server; going by the
+// extension alone would also let through API paths like /timers/3.1.
+func isAsset(p string) bool {
+	if ext := path.Ext(p); ext == "" || ext == ".html" {
+		return false
+	}
+	fi, err := os.Stat(filepath.Join(staticDir(), filepath.FromSlash(path.Clean("/"+p))))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// requireAuth lets requests through only with a valid session cookie or API
+// token. Browsers asking for a page are sent to the login page instead of a 401.
+func (a *API) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublic(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			a.tokenAuth(w, r, strings.TrimSpace(bearer), next)
+			return
+		}
+		if c, err := r.Cookie(auth.SessionCookie); err == nil && c.Value != "" {
+			u, err := db.GetSessionUser(r.Context(), auth.HashToken(c.Value))
+			if err == nil {
+				next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), u)))
+				return
+			}
+			if !errors.Is(err, db.ErrNotFound) {
+				slog.Error("session lookup failed", "err", err, "req_id", middleware.GetReqID(r.Context()))
+				httpError(w, r, http.StatusInternalServerError, "session lookup failed")
+				return
+			}
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			redirect(w, loginPath(r))
+			return
+		}
+		httpError(w, r, http.StatusUnauthorized, "login required")
+	})
+}
+
+// redirect sends a relative Location as-is; http.Redirect would make it
+// absolute and drop the nginx /hektor/ prefix.
+func redirect(w http.ResponseWriter, location string) {
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// loginPath is relative so it works behind the nginx /hektor/ prefix.
+func loginPath(r *http.Request) string {
+	depth := strings.Count(strings.Trim(r.URL.Path, "/"), "/")
+	return strings.Repeat("../", depth) + "login"
+}
+
+func (a *API) loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, a.staticFile("login.html"))
+}
+
+func (a *API) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid form")
+		return
+	}
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	u, err := auth.Authenticate(r.Context(), username, r.PostForm.Get("password"))
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		slog.Warn("login failed", "username", username, "ip", clientIP(r), "req_id", middleware.GetReqID(r.Context()))
+		redirect(w, "login?error=1")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "login failed")
+		return
+	}
+
+	token, hash := auth.NewToken()
+	sess, err := db.CreateSession(r.Context(), hash, u.ID, a.sessionTTL())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to create session")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	slog.Info("login", "username", u.Username, "ip", clientIP(r), "expires", sess.ExpiresAt)
+	redirect(w, "./")
+}
+
+func (a *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(auth.SessionCookie); err == nil {
+		_ = db.DeleteSession(r.Context(), auth.HashToken(c.Value))
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	redirect(w, "login")
+}
+
+func (a *API) sessionTTL() time.Duration {
+	if a.SessionTTL > 0 {
+		return a.SessionTTL
+	}
+	return DefaultSessionTTL
+}
+
+// trustedProxies are the peers besides loopback whose X-Real-IP is believed.
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies sets, from "10.0.0.2,192.168.1.0/24", which peers other
+// than loopback are reverse proxies allowed to name the client in X-Real-IP.
+func SetTrustedProxies(s string) error {
+	var out []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			a, aerr := netip.ParseAddr(part)
+			if aerr != nil {
+				return fmt.Errorf("%q is not an address or CIDR range", part)
+			}
+			p = netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen())
+		}
+		out = append(out, p.Masked())
+	}
+	trustedProxies = out
+	return nil
+}
+
+// clientIP is the address nginx saw the request come from. X-Real-IP is only
+// believed from loopback or a trusted proxy; anyone else could make up a new
+// one for every request.
+func clientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.Trim(host, "[]")
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && trustedProxy(host) {
+		return ip
+	}
+	return host
+}
+
+func trustedProxy(host string) bool {
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	if a.IsLoopback() {
+		return true
+	}
+	for _, p := range trustedProxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
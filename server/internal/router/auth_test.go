@@ -0,0 +1,41 @@
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	old := trustedProxies
+	t.Cleanup(func() { trustedProxies = old })
+	if err := SetTrustedProxies("10.0.0.0/8, 192.168.1.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTrustedProxies("10.0.0.0/8,nginx"); err == nil {
+		t.Fatal("SetTrustedProxies accepted a host name")
+	}
+
+	tests := []struct {
+		remote, realIP, want string
+	}{
+		{"127.0.0.1:5000", "198.51.100.7", "198.51.100.7"},
+		{"[::1]:5000", "198.51.100.7", "198.51.100.7"},
+		{"10.1.2.3:5000", "198.51.100.7", "198.51.100.7"},
+		{"[::ffff:10.1.2.3]:5000", "198.51.100.7", "198.51.100.7"},
+		{"192.168.1.2:5000", "198.51.100.7", "198.51.100.7"},
+		{"192.168.1.3:5000", "198.51.100.7", "192.168.1.3"},
+		{"203.0.113.9:5000", "198.51.100.7", "203.0.113.9"},
+		{"[2001:db8::1]:5000", "198.51.100.7", "2001:db8::1"},
+		{"127.0.0.1:5000", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP from %s with X-Real-IP %q = %q, want %q", tt.remote, tt.realIP, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/scene"
+)
+
+// BulkRelaysRequest sets several relays at once: either States, keyed by
+// relay number ("1".."8"), or a named Action.
+type BulkRelaysRequest struct {
+	States map[string]bool `json:"states,omitempty"`
+	Action string          `json:"action,omitempty"`
+}
+
+type BulkRelaysResponse struct {
+	// Mask is the bitmask the board confirmed; bit n-1 is relay n.
+	Mask    int     `json:"mask"`
+	Changed []int   `json:"changed"`
+	Relays  []Relay `json:"relays"`
+}
+
+// commandCheck is what one command needs from the caller: a scope, the
+// action's confirmation if it's guarded, and a share of a resource's rate
+// limit.
+type commandCheck struct {
+	Scope, Action, Resource string
+}
+
+func relayCheck(id string) commandCheck {
+	return commandCheck{Scope: auth.RelayScope(id), Action: auth.RelayAction(id), Resource: "relay:" + id}
+}
+
+// permit runs checks for a request that issues several commands at once.
+// Every scope is checked before any confirmation or rate limit is spent. On
+// failure it writes the error response and returns false.
+func (a *API) permit(w http.ResponseWriter, r *http.Request, checks []commandCheck) bool {
+	if !allowedScopes(w, r, checks) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, c := range checks {
+		if c.Action != "" && !seen[c.Action] {
+			seen[c.Action] = true
+			if !a.confirm(w, r, c.Action) {
+				return false
+			}
+		}
+	}
+	for _, c := range checks {
+		if ok, wait := a.limiter.allowResource(r, c.Resource); !ok {
+			rateLimited(w, r, c.Resource, wait)
+			return false
+		}
+	}
+	return true
+}
+
+// allowedScopes checks only the scopes of checks, writing a 403 naming every
+// missing one.
+func allowedScopes(w http.ResponseWriter, r *http.Request, checks []commandCheck) bool {
+	var denied []string
+	for _, c := range checks {
+		allowed, err := auth.Allowed(r.Context(), c.Scope)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, "permission lookup failed")
+			return false
+		}
+		if !allowed && !slices.Contains(denied, c.Scope) {
+			denied = append(denied, c.Scope)
+		}
+	}
+	if len(denied) > 0 {
+		slog.Warn("permission denied", "actor", actor(r), "scopes", denied, "path", r.URL.Path)
+		httpError(w, r, http.StatusForbidden, "not permitted: "+strings.Join(denied, " "))
+		return false
+	}
+	return true
+}
+
+func (a *API) setRelaysHandler(w http.ResponseWriter, r *http.Request) {
+	var req BulkRelaysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	mask, which, ok := scene.RelayBits(req.States, req.Action)
+	if !ok {
+		httpError(w, r, http.StatusBadRequest, `want "states" keyed by relay 1-8, or an "action" (all_off, all_on)`)
+		return
+	}
+
+	// Relays already in the wanted state are left alone, so they need no
+	// permission, PIN or rate limit budget.
+	change := (a.Devices.RelayMask() ^ mask) & which
+	var checks []commandCheck
+	for i := 0; i < 8; i++ {
+		if change&(1<<i) != 0 {
+			checks = append(checks, relayCheck(strconv.Itoa(i+1)))
+		}
+	}
+	if !a.permit(w, r, checks) {
+		return
+	}
+
+	command := "relays.set"
+	if req.Action != "" {
+		command = "relays." + req.Action
+	}
+	// Only the relays that were checked may be switched, even if another
+	// relay moved since the snapshot above.
+	got, err := a.Devices.SetRelays(r.Context(), mask, change, origin(r, command))
+	a.audit(r, command, "relays", req, err)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	res := BulkRelaysResponse{Mask: int(got), Changed: []int{}, Relays: a.relays(r)}
+	for i := 0; i < 8; i++ {
+		if change&(1<<i) != 0 {
+			res.Changed = append(res.Changed, i+1)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
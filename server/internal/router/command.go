@@ -0,0 +1,126 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"relaypanel/internal/adb"
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+	"relaypanel/internal/scene"
+)
+
+// RunTarget carries out a stored command for an automation such as a
+// schedule, through the same device calls as the API, and audits it as
+// o.Actor. Confirmation PINs and rate limits don't apply; whoever stored the
+// target was checked for its scopes instead.
+func (a *API) RunTarget(ctx context.Context, o device.Origin, t db.Target) error {
+	var action, target string
+	var err error
+	switch t.Type {
+	case db.TargetRelays:
+		action, target = "relays.set", "relays"
+		if t.Action != "" {
+			action = "relays." + t.Action
+		}
+		mask, which, ok := scene.RelayBits(t.States, t.Action)
+		if !ok {
+			err = errors.New("invalid relay target")
+			break
+		}
+		_, err = a.Devices.SetRelays(ctx, mask, which, o)
+	case db.TargetDoorPolicy:
+		action, target = "door.policy", "door"
+		err = auth.SetDoorPolicy(ctx, t.Policy)
+	case db.TargetScene:
+		action, target = "scene.run", "scene:"+strconv.FormatInt(t.SceneID, 10)
+		if len(t.Steps) == 0 {
+			err = errors.New("scene target has no steps; save it again")
+			break
+		}
+		err = scene.Failed(a.Scenes.Run(ctx, &db.Scene{ID: t.SceneID, Steps: t.Steps}, o))
+	case db.TargetTV:
+		action, target = "tv."+t.Action, "tv"
+		code, ok := adb.Actions[t.Action]
+		switch {
+		case !ok:
+			err = fmt.Errorf("unknown tv action %q", t.Action)
+		case a.ADB == nil:
+			err = adb.ErrNotInstalled
+		default:
+			tctx, cancel := context.WithTimeout(ctx, tvTimeout)
+			err = a.ADB.SendKey(tctx, code)
+			cancel()
+		}
+	case db.TargetDoor:
+		action, target = "door.buzz", "door"
+		err = a.buzzDoor(ctx)
+	default:
+		action, target = "unknown", t.Type
+		err = fmt.Errorf("unknown target type %q", t.Type)
+	}
+	recordAudit(ctx, db.AuditEntry{Actor: o.Actor, ActorKind: db.ActorSystem}, action, target, t, err)
+	return err
+}
+
+// validTarget checks a target before it's stored. A scene target gets a copy
+// of the scene's steps, which allowedTarget checks and RunTarget runs, so
+// whoever may edit the scene can't change what the target does.
+func validTarget(ctx context.Context, t *db.Target) error {
+	switch t.Type {
+	case db.TargetRelays:
+		if _, _, ok := scene.RelayBits(t.States, t.Action); !ok {
+			return errors.New(`relays target: want "states" keyed by relay 1-8, or an "action" (all_off, all_on)`)
+		}
+	case db.TargetDoorPolicy:
+		return auth.ValidDoorPolicy(t.Policy)
+	case db.TargetScene:
+		sc, err := db.GetScene(ctx, t.SceneID)
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("unknown scene %d", t.SceneID)
+		} else if err != nil {
+			return err
+		}
+		t.Steps = sc.Steps
+	case db.TargetTV:
+		if _, ok := adb.Actions[t.Action]; !ok {
+			return fmt.Errorf("unknown tv action %q", t.Action)
+		}
+	case db.TargetDoor:
+	default:
+		return fmt.Errorf("unknown target type %q (relays, door_policy, scene, tv or door)", t.Type)
+	}
+	return nil
+}
+
+// allowedTarget checks the caller may store t to run unattended: they need
+// the scopes of every command it runs, and changing the door policy is for
+// admins. It writes the 403 itself.
+func allowedTarget(w http.ResponseWriter, r *http.Request, t db.Target) bool {
+	var checks []commandCheck
+	switch t.Type {
+	case db.TargetRelays:
+		_, which, _ := scene.RelayBits(t.States, t.Action)
+		for i := 0; i < 8; i++ {
+			if which&(1<<i) != 0 {
+				checks = append(checks, relayCheck(strconv.Itoa(i+1)))
+			}
+		}
+	case db.TargetDoorPolicy:
+		if !isAdmin(r) {
+			httpError(w, r, http.StatusForbidden, "only admins can change the door policy")
+			return false
+		}
+	case db.TargetScene:
+		checks = sceneChecks(&db.Scene{Steps: t.Steps})
+	case db.TargetTV:
+		checks = append(checks, commandCheck{Scope: auth.ScopeTVControl, Resource: "tv"})
+	case db.TargetDoor:
+		checks = append(checks, commandCheck{Scope: auth.ScopeDoorBuzz, Action: auth.ActionDoor, Resource: "door"})
+	}
+	return allowedScopes(w, r, checks)
+}
@@ -0,0 +1,79 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ConfirmHeader carries the PIN or TOTP code for a guarded action.
+const ConfirmHeader = "X-Confirm-Code"
+
+// confirm checks the code for a guarded action and writes the error response
+// if it's missing or wrong. API tokens aren't asked: they're already a
+// credential scoped to the action, and scripts can't type a PIN.
+func (a *API) confirm(w http.ResponseWriter, r *http.Request, action string) bool {
+	if auth.TokenFrom(r.Context()) != nil {
+		return true
+	}
+	who := actor(r)
+	err := auth.Confirm(r.Context(), action, who, clientIP(r), r.Header.Get(ConfirmHeader))
+	if err == nil {
+		return true
+	}
+	switch status, retryAfter := confirmStatus(err); status {
+	case http.StatusForbidden:
+		slog.Warn("confirmation failed", "action", action, "actor", who, "ip", clientIP(r), "req_id", middleware.GetReqID(r.Context()))
+	case http.StatusTooManyRequests:
+		slog.Warn("confirmation locked out", "action", action, "actor", who, "ip", clientIP(r), "retry_after", retryAfter)
+	}
+	writeErr(w, r, err)
+	return false
+}
+
+// confirmStatus maps an auth.Confirm error to an HTTP status, plus the
+// seconds to wait when locked out.
+func confirmStatus(err error) (status, retryAfter int) {
+	var locked *auth.LockedError
+	switch {
+	case errors.Is(err, auth.ErrConfirmRequired):
+		return http.StatusPreconditionRequired, 0
+	case errors.Is(err, auth.ErrConfirmFailed):
+		return http.StatusForbidden, 0
+	case errors.As(err, &locked):
+		return http.StatusTooManyRequests, int(time.Until(locked.Until).Seconds()) + 1
+	}
+	return http.StatusInternalServerError, 0
+}
+
+// guardedActions lists the actions that ask for a code, for the panel.
+func guardedActions(r *http.Request) []string {
+	out := []string{}
+	if auth.TokenFrom(r.Context()) != nil {
+		return out
+	}
+	guards, err := db.ListActionGuards(r.Context())
+	if err != nil {
+		slog.Error("failed to list action guards", "err", err)
+		return out
+	}
+	for _, g := range guards {
+		out = append(out, g.Action)
+	}
+	return out
+}
+
+func (a *API) listConfirmationsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := db.ListConfirmations(r.Context(), r.URL.Query().Get("failed") != "", 500)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list confirmations")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"relaypanel/internal/auth"
+)
+
+type DoorPolicyRequest struct {
+	Policy string `json:"policy"`
+}
+
+func (a *API) getDoorPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := auth.DoorPolicy(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load door policy")
+		return
+	}
+	writeJSON(w, http.StatusOK, DoorPolicyRequest{Policy: p})
+}
+
+func (a *API) setDoorPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var req DoorPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if err := auth.ValidDoorPolicy(req.Policy); err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	err := auth.SetDoorPolicy(r.Context(), req.Policy)
+	a.audit(r, "door.policy", "door", req, err)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to store door policy")
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
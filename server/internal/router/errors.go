@@ -0,0 +1,120 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"relaypanel/internal/adb"
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ErrorResponse is the body of every error the API returns.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	// Code is stable and meant for programs; Message is for people.
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// statusCodes are the codes used when a handler doesn't pick a more specific one.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           "bad_request",
+	http.StatusUnauthorized:         "unauthorized",
+	http.StatusForbidden:            "forbidden",
+	http.StatusNotFound:             "not_found",
+	http.StatusConflict:             "conflict",
+	http.StatusGone:                 "gone",
+	http.StatusLocked:               "door_locked",
+	http.StatusPreconditionRequired: "confirmation_required",
+	http.StatusTooManyRequests:      "too_many_requests",
+	http.StatusInternalServerError:  "internal",
+	http.StatusBadGateway:           "bad_gateway",
+	http.StatusServiceUnavailable:   "unavailable",
+	http.StatusGatewayTimeout:       "timeout",
+}
+
+// httpError writes the error envelope with the default code for status.
+func httpError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeError(w, r, status, code, msg)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeJSON(w, status, ErrorResponse{Error: APIError{
+		Code:      code,
+		Message:   msg,
+		RequestID: middleware.GetReqID(r.Context()),
+	}})
+}
+
+// writeErr maps an error from the device, adb, db or auth packages to its
+// status and code. Anything unrecognised is logged and reported as a 500
+// without the details.
+func writeErr(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := errorStatus(err)
+	msg := err.Error()
+	switch status {
+	case http.StatusInternalServerError:
+		slog.Error("request failed", "path", r.URL.Path, "err", err, "req_id", middleware.GetReqID(r.Context()))
+		msg = "internal error"
+	case http.StatusTooManyRequests:
+		if _, retryAfter := confirmStatus(err); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+	}
+	writeError(w, r, status, code, msg)
+}
+
+// errorStatus is the status and machine-readable code for err.
+func errorStatus(err error) (int, string) {
+	var locked *auth.LockedError
+	switch {
+	case errors.Is(err, device.ErrInvalidRelay):
+		return http.StatusBadRequest, "invalid_relay"
+	case errors.Is(err, device.ErrUnknownDevice):
+		return http.StatusNotFound, "unknown_device"
+	case errors.Is(err, device.ErrNotConnected):
+		return http.StatusServiceUnavailable, "device_not_connected"
+	case errors.Is(err, device.ErrWriteFailed):
+		return http.StatusServiceUnavailable, "device_write_failed"
+	case errors.Is(err, device.ErrNotConfirmed):
+		return http.StatusGatewayTimeout, "device_timeout"
+	case errors.Is(err, device.ErrResetUnsupported):
+		return http.StatusConflict, "reset_unsupported"
+	case errors.Is(err, adb.ErrNotInstalled):
+		return http.StatusServiceUnavailable, "adb_not_installed"
+	case errors.Is(err, adb.ErrUnauthorized):
+		return http.StatusBadGateway, "tv_unauthorized"
+	case errors.Is(err, adb.ErrUnreachable):
+		return http.StatusGatewayTimeout, "tv_unreachable"
+	case errors.Is(err, adb.ErrFailed):
+		return http.StatusBadGateway, "tv_command_failed"
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict, "conflict"
+	case errors.Is(err, auth.ErrConfirmRequired):
+		return http.StatusPreconditionRequired, "confirmation_required"
+	case errors.Is(err, auth.ErrConfirmFailed):
+		return http.StatusForbidden, "confirmation_failed"
+	case errors.As(err, &locked):
+		return http.StatusTooManyRequests, "confirmation_locked"
+	case errors.Is(err, auth.ErrDoorLocked):
+		return http.StatusLocked, "door_locked"
+	}
+	return http.StatusInternalServerError, "internal"
+}
@@ -0,0 +1,78 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"relaypanel/internal/events"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+// eventsHandler streams live state as Server-Sent Events. Clients resuming
+// with Last-Event-ID get what they missed; if that's no longer available, or
+// the ID is from before a restart, they get a "resync" event and should
+// reload /status.
+func (a *API) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Events == nil {
+		httpError(w, r, http.StatusServiceUnavailable, "events unavailable")
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers proxied responses unless told not to
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	missed, complete, ch, cancel := a.Events.Resume(lastID)
+	defer cancel()
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		a.writeEvent(w, e)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				// fell too far behind; the browser reconnects and resumes
+				slog.Warn("events subscriber dropped", "ip", clientIP(r))
+				return
+			}
+			a.writeEvent(w, e)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *API) writeEvent(w http.ResponseWriter, e events.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode event", "type", e.Type, "err", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", a.Events.StreamID(e), e.Type, data)
+}
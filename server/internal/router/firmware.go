@@ -0,0 +1,188 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/ota"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxFirmwareSize = 8 << 20
+
+type StartOTARequest struct {
+	FirmwareID int64 `json:"firmware_id"`
+}
+
+type SetDeviceOTARequest struct {
+	Host     string `json:"host"`
+	Port     int64  `json:"port"`
+	Password string `json:"password"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (a *API) listDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	devs, err := db.ListDevices(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list devices")
+		return
+	}
+	writeJSON(w, http.StatusOK, devs)
+}
+
+func (a *API) setDeviceOTAHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	dev, err := db.GetDevice(r.Context(), name)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown device")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load device")
+		return
+	}
+	req := SetDeviceOTARequest{Host: dev.Host, Port: dev.OTAPort, Password: dev.OTAPassword}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if err := db.UpdateDeviceOTA(r.Context(), name, req.Host, req.Port, req.Password); err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to update device")
+		return
+	}
+	slog.Info("device ota settings updated", "device", name, "host", req.Host, "port", req.Port)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) listFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	fws, err := db.ListFirmware(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list firmware")
+		return
+	}
+	writeJSON(w, http.StatusOK, fws)
+}
+
+// uploadFirmwareHandler stores the raw request body as a firmware image:
+//
+//	curl --data-binary @build/esp32-relay.ino.bin 'http://host/firmware?name=esp32-relay'
+func (a *API) uploadFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		httpError(w, r, http.StatusBadRequest, "missing name")
+		return
+	}
+	image, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFirmwareSize))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "failed to read firmware")
+		return
+	}
+	if len(image) == 0 {
+		httpError(w, r, http.StatusBadRequest, "empty firmware")
+		return
+	}
+	path, sum, err := a.OTA.SaveImage(image)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to store firmware")
+		return
+	}
+	fw, err := db.CreateFirmware(r.Context(), name, path, int64(len(image)), sum)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to record firmware")
+		return
+	}
+	slog.Info("firmware uploaded", "name", name, "size", len(image), "md5", sum)
+	writeJSON(w, http.StatusCreated, fw)
+}
+
+func (a *API) startOTAHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	dev, err := db.GetDevice(r.Context(), name)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown device")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load device")
+		return
+	}
+
+	var req StartOTARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	fw, err := db.GetFirmware(r.Context(), req.FirmwareID)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown firmware")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load firmware")
+		return
+	}
+
+	id, err := db.CreateFirmwareUpdate(r.Context(), dev.Name, fw)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to record update")
+		return
+	}
+	target := ota.Target{
+		Host:             dev.Host,
+		Port:             int(dev.OTAPort),
+		Password:         dev.OTAPassword,
+		PreviousPassword: dev.OTAPasswordPrev,
+	}
+	job, err := a.OTA.Start(id, dev.Name, fw.Name, fw.Path, target, func(j *ota.Job) {
+		if err := db.FinishFirmwareUpdate(context.Background(), j.ID, j.State, j.Error); err != nil {
+			slog.Error("failed to record firmware update", "job", j.ID, "err", err)
+		}
+		if j.State == ota.StateDone && !j.PasswordFallback && dev.OTAPasswordPrev != "" {
+			if err := db.ClearPreviousOTAPassword(context.Background(), dev.Name); err != nil {
+				slog.Error("failed to clear previous ota password", "device", dev.Name, "err", err)
+			}
+		}
+	})
+	if err != nil {
+		_ = db.FinishFirmwareUpdate(r.Context(), id, ota.StateFailed, err.Error())
+		httpError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (a *API) listOTAJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.OTA.Jobs())
+}
+
+func (a *API) getOTAJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid job id")
+		return
+	}
+	job := a.OTA.Job(id)
+	if job == nil {
+		httpError(w, r, http.StatusNotFound, "unknown job")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (a *API) firmwareHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ups, err := db.ListFirmwareUpdates(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list firmware history")
+		return
+	}
+	writeJSON(w, http.StatusOK, ups)
+}
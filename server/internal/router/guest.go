@@ -0,0 +1,237 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	defaultGuestLinkTTL = 24 * time.Hour
+	maxGuestLinkTTL     = 30 * 24 * time.Hour
+)
+
+type CreateGuestLinkRequest struct {
+	Label     string `json:"label"`
+	ExpiresIn string `json:"expires_in"` // Go duration, e.g. "2h"; default 24h
+	MaxUses   int64  `json:"max_uses"`   // default 1
+}
+
+type GuestLinkResponse struct {
+	db.GuestLink
+	Active bool   `json:"active"`
+	URL    string `json:"url"`
+}
+
+// guestURL builds the link handed to the guest. nginx can pass the /hektor
+// prefix in X-Forwarded-Prefix.
+func guestURL(r *http.Request, token string) string {
+	scheme := "http"
+	if isSecure(r) {
+		scheme = "https"
+	}
+	prefix := strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/")
+	return scheme + "://" + requestHost(r) + prefix + "/guest/" + token
+}
+
+func (a *API) guestLinkResponse(r *http.Request, l *db.GuestLink) (GuestLinkResponse, error) {
+	token, err := auth.GuestLinkToken(r.Context(), l)
+	if err != nil {
+		return GuestLinkResponse{}, err
+	}
+	return GuestLinkResponse{GuestLink: *l, Active: l.Active(time.Now()), URL: guestURL(r, token)}, nil
+}
+
+func (a *API) createGuestLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateGuestLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	ttl := defaultGuestLinkTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxGuestLinkTTL {
+			httpError(w, r, http.StatusBadRequest, "expires_in must be a duration up to 720h")
+			return
+		}
+		ttl = d
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		httpError(w, r, http.StatusBadRequest, "max_uses must be positive")
+		return
+	}
+
+	var createdBy *int64
+	if u := auth.UserFrom(r.Context()); u != nil {
+		createdBy = &u.ID
+	}
+	l, err := db.CreateGuestLink(r.Context(), strings.TrimSpace(req.Label), createdBy, time.Now().Add(ttl), req.MaxUses)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to create guest link")
+		return
+	}
+	resp, err := a.guestLinkResponse(r, l)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to sign guest link")
+		return
+	}
+	slog.Info("guest link created", "link_id", l.ID, "label", l.Label, "actor", actor(r), "expires", l.ExpiresAt, "max_uses", l.MaxUses)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (a *API) listGuestLinksHandler(w http.ResponseWriter, r *http.Request) {
+	links, err := db.ListGuestLinks(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list guest links")
+		return
+	}
+	out := make([]GuestLinkResponse, 0, len(links))
+	for i := range links {
+		resp, err := a.guestLinkResponse(r, &links[i])
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, "failed to sign guest link")
+			return
+		}
+		out = append(out, resp)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func guestLinkID(w http.ResponseWriter, r *http.Request) (*db.GuestLink, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid guest link id")
+		return nil, false
+	}
+	l, err := db.GetGuestLink(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown guest link")
+		return nil, false
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load guest link")
+		return nil, false
+	}
+	return l, true
+}
+
+func (a *API) revokeGuestLinkHandler(w http.ResponseWriter, r *http.Request) {
+	l, ok := guestLinkID(w, r)
+	if !ok {
+		return
+	}
+	if err := db.RevokeGuestLink(r.Context(), l.ID); err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to revoke guest link")
+		return
+	}
+	slog.Info("guest link revoked", "link_id", l.ID, "actor", actor(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) guestLinkUsesHandler(w http.ResponseWriter, r *http.Request) {
+	l, ok := guestLinkID(w, r)
+	if !ok {
+		return
+	}
+	uses, err := db.ListGuestLinkUses(r.Context(), l.ID)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list uses")
+		return
+	}
+	writeJSON(w, http.StatusOK, uses)
+}
+
+// guestLink resolves the {token} of a public guest URL, answering 404 for
+// forged tokens and 410 for links that can no longer be used.
+func guestLink(w http.ResponseWriter, r *http.Request) (*db.GuestLink, bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	l, err := auth.VerifyGuestLinkToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, auth.ErrBadGuestToken) {
+		slog.Warn("invalid guest link", "ip", clientIP(r), "req_id", middleware.GetReqID(r.Context()))
+		httpError(w, r, http.StatusNotFound, "link not found")
+		return nil, false
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to check link")
+		return nil, false
+	}
+	if !l.Active(time.Now()) {
+		httpError(w, r, http.StatusGone, "this link has expired")
+		return nil, false
+	}
+	return l, true
+}
+
+func (a *API) guestPageHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := guestLink(w, r); !ok {
+		return
+	}
+	http.ServeFile(w, r, a.staticFile("guest.html"))
+}
+
+func (a *API) guestBuzzHandler(w http.ResponseWriter, r *http.Request) {
+	l, ok := guestLink(w, r)
+	if !ok {
+		return
+	}
+	use := db.GuestLinkUse{LinkID: l.ID, IP: clientIP(r), UserAgent: r.UserAgent(), UsedAt: time.Now()}
+	defer func() {
+		if err := db.InsertGuestLinkUse(r.Context(), use); err != nil {
+			slog.Error("failed to record guest link use", "link_id", l.ID, "err", err)
+		}
+		slog.Info("guest buzz", "link_id", l.ID, "label", l.Label, "ip", use.IP, "result", use.Result, "req_id", middleware.GetReqID(r.Context()))
+	}()
+
+	if err := auth.CheckDoor(r.Context(), true); errors.Is(err, auth.ErrDoorLocked) {
+		use.Result = "locked"
+		writeErr(w, r, err)
+		return
+	} else if err != nil {
+		use.Result = "error"
+		httpError(w, r, http.StatusInternalServerError, "failed to check door policy")
+		return
+	}
+	claimed, err := db.ClaimGuestLinkUse(r.Context(), l.ID)
+	if err != nil {
+		use.Result = "error"
+		httpError(w, r, http.StatusInternalServerError, "failed to check link")
+		return
+	}
+	if !claimed {
+		use.Result = "expired"
+		httpError(w, r, http.StatusGone, "this link has expired")
+		return
+	}
+	err = a.Devices.BuzzDoor()
+	recordAudit(context.WithoutCancel(r.Context()), db.AuditEntry{
+		Actor:     "guest:" + strconv.FormatInt(l.ID, 10),
+		ActorKind: db.ActorGuest,
+		IP:        use.IP,
+		RequestID: middleware.GetReqID(r.Context()),
+	}, "door.buzz", "door", map[string]string{"link": l.Label}, err)
+	if err != nil {
+		use.Result = "device error"
+		_ = db.ReleaseGuestLinkUse(r.Context(), l.ID)
+		httpError(w, r, http.StatusServiceUnavailable, "the door buzzer is offline")
+		return
+	}
+	use.Result = "buzzed"
+	writeJSON(w, http.StatusOK, map[string]any{
+		"remaining":  l.MaxUses - l.Uses - 1,
+		"expires_at": l.ExpiresAt,
+	})
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+)
+
+const defaultHistoryWindow = 24 * time.Hour
+
+// RelayHistoryEntry is one switch of a single relay.
+type RelayHistoryEntry struct {
+	At      time.Time `json:"at"`
+	State   bool      `json:"state"`
+	Command string    `json:"command,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+}
+
+// Interval is a span of time a relay was on.
+type Interval struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type RelayTimeline struct {
+	ID        int        `json:"id"`
+	Label     string     `json:"label"`
+	OnSeconds float64    `json:"on_seconds"`
+	On        []Interval `json:"on"`
+}
+
+type TimelineResponse struct {
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Relays []RelayTimeline `json:"relays"`
+}
+
+// origin credits a device command to the request's caller in the relay history.
+func origin(r *http.Request, command string) device.Origin {
+	return device.Origin{Command: command, Actor: actor(r)}
+}
+
+// relayHistoryHandler lists the switches of one relay in ?from=&to=
+// (default: the last day), oldest first.
+func (a *API) relayHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := relayID(w, r)
+	if !ok {
+		return
+	}
+	from, to, err := parseTimeRange(r, defaultHistoryWindow)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	ts, err := db.ListRelayTransitions(r.Context(), from, to)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list relay history")
+		return
+	}
+	bit := int64(1) << (id - 1)
+	out := []RelayHistoryEntry{}
+	for _, t := range ts {
+		if (t.Prev^t.Mask)&bit == 0 {
+			continue
+		}
+		out = append(out, RelayHistoryEntry{At: t.At, State: t.Mask&bit != 0, Command: t.Command, Actor: t.Actor})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// relayTimelineHandler reports when each relay was on in ?from=&to=
+// (default: the last day), for drawing a timeline.
+func (a *API) relayTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r, defaultHistoryWindow)
+	if err != nil || !from.Before(to) {
+		httpError(w, r, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	mask, err := db.RelayMaskAt(r.Context(), from)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load relay history")
+		return
+	}
+	ts, err := db.ListRelayTransitions(r.Context(), from, to)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list relay history")
+		return
+	}
+
+	// Nothing is known past now, so an open interval ends there.
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	states := a.Devices.RelayStates()
+	res := TimelineResponse{From: from, To: to, Relays: make([]RelayTimeline, len(states))}
+	var since [8]time.Time
+	for i := range res.Relays {
+		res.Relays[i] = RelayTimeline{ID: i + 1, Label: states[i].Label, On: []Interval{}}
+		if mask&(1<<i) != 0 {
+			since[i] = from
+		}
+	}
+	closeInterval := func(i int, at time.Time) {
+		if at.After(since[i]) {
+			tl := &res.Relays[i]
+			tl.On = append(tl.On, Interval{From: since[i], To: at})
+			tl.OnSeconds += at.Sub(since[i]).Seconds()
+		}
+		since[i] = time.Time{}
+	}
+	for _, t := range ts {
+		for i := range res.Relays {
+			bit := int64(1) << i
+			switch {
+			case t.Mask&bit != 0 && since[i].IsZero():
+				since[i] = t.At
+			case t.Mask&bit == 0 && !since[i].IsZero():
+				closeInterval(i, t.At)
+			}
+		}
+	}
+	for i := range res.Relays {
+		if !since[i].IsZero() {
+			closeInterval(i, end)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// IdempotencyHeader lets a client retry a mutation safely: a repeat of the
+// same key gets the first response back instead of running again.
+const IdempotencyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL is how long a key and its response are remembered.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKey = 255
+
+type idempotentResponse struct {
+	request string // method and path the key was first used with
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	pruned  time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &idempotencyStore{ttl: ttl, entries: make(map[string]*idempotentResponse)}
+}
+
+// middleware replays the stored response for a repeated Idempotency-Key.
+// Keys are per caller, so two users can't see each other's responses. Only
+// successful responses are kept: after a failure (device offline, PIN
+// required, ...) the key is free again and the retry really runs.
+func (s *idempotencyStore) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			key = ""
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, r, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key is too long")
+			return
+		}
+
+		caller := actor(r)
+		if caller == "" {
+			caller = "ip:" + clientIP(r)
+		}
+		id := caller + "\x00" + key
+		request := r.Method + " " + r.URL.Path
+
+		for {
+			s.mu.Lock()
+			s.pruneLocked(time.Now())
+			e, ok := s.entries[id]
+			if !ok {
+				e = &idempotentResponse{request: request, done: make(chan struct{})}
+				s.entries[id] = e
+				s.mu.Unlock()
+				s.run(e, id, next, w, r)
+				return
+			}
+			s.mu.Unlock()
+
+			if e.request != request {
+				writeError(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused",
+					"Idempotency-Key was already used for "+e.request)
+				return
+			}
+			// A retry that overtook the original waits for it to finish.
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			if e.status == 0 {
+				continue // the first attempt failed and gave the key up
+			}
+			slog.Info("idempotent replay", "key", key, "actor", caller, "path", r.URL.Path, "req_id", middleware.GetReqID(r.Context()))
+			h := w.Header()
+			for k, v := range e.header {
+				h[k] = v
+			}
+			h.Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			_, _ = w.Write(e.body)
+			return
+		}
+	})
+}
+
+func (s *idempotencyStore) run(e *idempotentResponse, id string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	rec := &responseRecorder{ResponseWriter: w}
+	defer func() {
+		s.mu.Lock()
+		if rec.status >= 200 && rec.status < 300 {
+			e.status = rec.status
+			e.header = w.Header().Clone()
+			e.header.Del("Set-Cookie")
+			e.body = rec.body.Bytes()
+			e.expires = time.Now().Add(s.ttl)
+		} else {
+			delete(s.entries, id)
+		}
+		s.mu.Unlock()
+		close(e.done)
+	}()
+	next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+}
+
+// pruneLocked drops expired keys, at most once a minute.
+func (s *idempotencyStore) pruneLocked(now time.Time) {
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	for id, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
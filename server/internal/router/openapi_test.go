@@ -0,0 +1,355 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+	"relaypanel/internal/events"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type spec map[string]any
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	var s spec
+	if err := json.Unmarshal(openAPISpec, &s); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return s
+}
+
+// operation returns the spec's operation for method and path, a path
+// relative to /api/v1 as written in the spec.
+func (s spec) operation(method, path string) map[string]any {
+	paths, _ := s["paths"].(map[string]any)
+	ops, _ := paths[path].(map[string]any)
+	op, _ := ops[strings.ToLower(method)].(map[string]any)
+	return op
+}
+
+// resolve follows a local $ref such as "#/components/schemas/Relay".
+func (s spec) resolve(node map[string]any) map[string]any {
+	ref, ok := node["$ref"].(string)
+	if !ok {
+		return node
+	}
+	var cur any = map[string]any(s)
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, _ := cur.(map[string]any)
+		cur = m[part]
+	}
+	out, _ := cur.(map[string]any)
+	return out
+}
+
+// jsonSchema returns the application/json schema of a request body or
+// response, or nil if it has none.
+func (s spec) jsonSchema(node any) map[string]any {
+	m, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+	content, _ := s.resolve(m)["content"].(map[string]any)
+	media, _ := content["application/json"].(map[string]any)
+	schema, _ := media["schema"].(map[string]any)
+	return schema
+}
+
+// check reports every way v, decoded JSON, doesn't match schema. Objects may
+// only carry documented properties unless the schema allows others.
+func (s spec) check(schema map[string]any, v any, at string) []string {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return []string{at + ": unresolved schema"}
+	}
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil {
+			return nil
+		}
+		return []string{at + ": null but not nullable"}
+	}
+	var errs []string
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, v) {
+		errs = append(errs, fmt.Sprintf("%s: %v not in %v", at, v, enum))
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: want object, got %T", at, v))
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %q", at, name))
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(obj)) {
+			if prop, ok := props[name].(map[string]any); ok {
+				errs = append(errs, s.check(prop, obj[name], at+"."+name)...)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case map[string]any:
+				errs = append(errs, s.check(extra, obj[name], at+"."+name)...)
+			case bool:
+				if !extra {
+					errs = append(errs, fmt.Sprintf("%s: undocumented property %q", at, name))
+				}
+			default:
+				if props != nil {
+					errs = append(errs, fmt.Sprintf("%s: undocumented property %q", at, name))
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: want array, got %T", at, v))
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range arr {
+			errs = append(errs, s.check(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want string, got %T", at, v))
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			errs = append(errs, fmt.Sprintf("%s: want integer, got %v", at, v))
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want number, got %T", at, v))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want boolean, got %T", at, v))
+		}
+	}
+	return errs
+}
+
+// TestOpenAPIMatchesV1Routes checks that the spec and v1Routes list the same
+// routes.
+func TestOpenAPIMatchesV1Routes(t *testing.T) {
+	s := loadSpec(t)
+
+	a := &API{RateLimits: DefaultRateLimits()}
+	a.limiter = newRateLimiter(a.RateLimits)
+	r := chi.NewRouter()
+	a.v1Routes(r)
+	routed := map[string]bool{}
+	err := chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documented := map[string]bool{}
+	for path, ops := range s["paths"].(map[string]any) {
+		for method := range ops.(map[string]any) {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	for _, route := range slices.Sorted(maps.Keys(routed)) {
+		if !documented[route] {
+			t.Errorf("%s is routed but not in openapi.json", route)
+		}
+	}
+	for _, route := range slices.Sorted(maps.Keys(documented)) {
+		if !routed[route] {
+			t.Errorf("%s is in openapi.json but not routed", route)
+		}
+	}
+}
+
+// testServer serves the API from a fresh database and returns it with a
+// session cookie for an admin.
+func testServer(t *testing.T) (*httptest.Server, *http.Cookie) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := filepath.Glob(filepath.Join(filepath.Dir(exe), db.DEFAULT_DB_NAME+"*"))
+	for _, f := range old {
+		_ = os.Remove(f)
+	}
+	ctx := context.Background()
+	db.Connect(ctx)
+	t.Cleanup(func() { _ = db.DB.Close() })
+
+	u, err := db.CreateUser(ctx, "admin", "x", auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, hash := auth.NewToken()
+	if _, err := db.CreateSession(ctx, hash, u.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &API{
+		Devices:    device.NewManager(),
+		Events:     events.NewHub(events.DefaultBacklog),
+		RateLimits: DefaultRateLimits(),
+	}
+	srv := httptest.NewServer(Router(api))
+	t.Cleanup(srv.Close)
+	return srv, &http.Cookie{Name: auth.SessionCookie, Value: token}
+}
+
+// TestOpenAPIBodies sends documented requests to the status, relays, door,
+// tv, scenes, schedules and rules endpoints and checks the bodies both ways
+// against openapi.json, error responses included. The steps share one
+// database and run in order.
+func TestOpenAPIBodies(t *testing.T) {
+	s := loadSpec(t)
+	srv, session := testServer(t)
+
+	tests := []struct {
+		method, route string // route as in the spec
+		url           string // relative to /api/v1; defaults to route
+		body          string
+		anonymous     bool
+		status        int
+	}{
+		{method: "GET", route: "/status", status: 200},
+		{method: "GET", route: "/status", anonymous: true, status: 401},
+
+		{method: "GET", route: "/relays", status: 200},
+		{method: "GET", route: "/relays/{id}", url: "/relays/3", status: 200},
+		{method: "GET", route: "/relays/{id}", url: "/relays/9", status: 400},
+		{method: "PATCH", route: "/relays/{id}", url: "/relays/3", body: `{"label":"Lamp"}`, status: 200},
+		{method: "PATCH", route: "/relays/{id}", url: "/relays/3", body: `{`, status: 400},
+		{method: "POST", route: "/relays/{id}/toggle", url: "/relays/3/toggle", status: 503},
+		{method: "POST", route: "/relays", body: `{"states":{"1":true,"2":false}}`, status: 503},
+
+		{method: "GET", route: "/door/policy", status: 200},
+		{method: "PUT", route: "/door/policy", body: `{"policy":"no_guests"}`, status: 200},
+		{method: "PUT", route: "/door/policy", body: `{"policy":"ajar"}`, status: 400},
+		{method: "POST", route: "/door/buzz", status: 503},
+
+		{method: "GET", route: "/tv/actions", status: 200},
+		{method: "POST", route: "/tv/actions/{action}", url: "/tv/actions/home", status: 503},
+		{method: "POST", route: "/tv/actions/{action}", url: "/tv/actions/warp", status: 404},
+
+		{method: "POST", route: "/scenes", body: `{"name":"Evening","steps":[{"type":"relays","states":{"1":true,"2":false}},{"type":"tv","action":"home","delay_ms":500}]}`, status: 201},
+		{method: "POST", route: "/scenes", body: `{"name":"Evening","steps":[{"type":"relays","action":"all_off"}]}`, status: 409},
+		{method: "POST", route: "/scenes", body: `{"name":"Broken","steps":[{"type":"teleport"}]}`, status: 400},
+		{method: "GET", route: "/scenes", status: 200},
+		{method: "GET", route: "/scenes/{id}", url: "/scenes/1", status: 200},
+		{method: "GET", route: "/scenes/{id}", url: "/scenes/99", status: 404},
+		{method: "PUT", route: "/scenes/{id}", url: "/scenes/1", body: `{"name":"Night","steps":[{"type":"relays","action":"all_off"},{"type":"door"}]}`, status: 200},
+
+		{method: "POST", route: "/schedules", body: `{"name":"Lights out","cron":"30 23 * * *","time_zone":"Europe/Berlin","target":{"type":"scene","scene_id":1}}`, status: 201},
+		{method: "POST", route: "/schedules", body: `{"name":"Wake","cron":"0 7 * * 1-5","target":{"type":"relays","states":{"4":true}},"on_missed":"run_once","missed_window_s":600}`, status: 201},
+		{method: "POST", route: "/schedules", body: `{"name":"Never","cron":"61 * * * *","target":{"type":"door"}}`, status: 400},
+		{method: "GET", route: "/schedules", status: 200},
+		{method: "GET", route: "/schedules/{id}", url: "/schedules/1", status: 200},
+		{method: "GET", route: "/schedules/{id}", url: "/schedules/99", status: 404},
+		{method: "PUT", route: "/schedules/{id}", url: "/schedules/2", body: `{"name":"Wake","cron":"15 7 * * 1-5","enabled":false,"target":{"type":"door_policy","policy":"open"}}`, status: 200},
+		{method: "GET", route: "/schedules/preview", url: "/schedules/preview?cron=0+7+*+*+*&time_zone=UTC&count=3", status: 200},
+		{method: "GET", route: "/schedules/sun", status: 404},
+		{method: "DELETE", route: "/schedules/{id}", url: "/schedules/1", status: 204},
+
+		{method: "POST", route: "/rules", body: `{"name":"Porch light","trigger":{"event":"ring"},"conditions":[{"type":"time","from":"18:00","to":"06:00","time_zone":"UTC"}],"actions":[{"type":"relays","states":{"5":true}}],"cooldown_s":60}`, status: 201},
+		{method: "GET", route: "/rules/{id}", url: "/rules/1", status: 200},
+		{method: "DELETE", route: "/rules/{id}", url: "/rules/1", status: 204},
+		{method: "DELETE", route: "/scenes/{id}", url: "/scenes/1", status: 204},
+	}
+	for _, tt := range tests {
+		url := tt.url
+		if url == "" {
+			url = tt.route
+		}
+		name := tt.method + " " + url
+		op := s.operation(tt.method, tt.route)
+		if op == nil {
+			t.Fatalf("%s: %s %s is not in openapi.json", name, tt.method, tt.route)
+		}
+
+		var body io.Reader
+		if tt.body != "" {
+			schema := s.jsonSchema(op["requestBody"])
+			if schema == nil {
+				t.Fatalf("%s: sends a body but openapi.json documents none", name)
+			}
+			var v any
+			if err := json.Unmarshal([]byte(tt.body), &v); err == nil && tt.status < 400 {
+				for _, e := range s.check(schema, v, "request") {
+					t.Errorf("%s: %s", name, e)
+				}
+			}
+			body = strings.NewReader(tt.body)
+		}
+
+		req, err := http.NewRequest(tt.method, srv.URL+"/api/v1"+url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tt.anonymous {
+			req.AddCookie(session)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", name, res.StatusCode, tt.status, bytes.TrimSpace(got))
+			continue
+		}
+
+		responses, _ := op["responses"].(map[string]any)
+		documented, ok := responses[fmt.Sprint(res.StatusCode)]
+		if !ok {
+			t.Errorf("%s: status %d is not documented", name, res.StatusCode)
+			continue
+		}
+		schema := s.jsonSchema(documented)
+		if schema == nil {
+			if len(bytes.TrimSpace(got)) != 0 {
+				t.Errorf("%s: openapi.json documents no body for %d but got %s", name, res.StatusCode, got)
+			}
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: Content-Type %q", name, ct)
+		}
+		var v any
+		if err := json.Unmarshal(got, &v); err != nil {
+			t.Errorf("%s: response is not json: %v", name, err)
+			continue
+		}
+		for _, e := range s.check(schema, v, "response") {
+			t.Errorf("%s: %s", name, e)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package router
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// sameOrigin rejects state-changing requests that a browser sent from another
+// site. Browsers always attach Origin (or at least Sec-Fetch-Site) to
+// cross-origin POSTs; scripts like curl send neither and are let through.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != requestHost(r) {
+				slog.Warn("cross-origin request rejected", "origin", origin, "host", requestHost(r), "path", r.URL.Path, "req_id", middleware.GetReqID(r.Context()))
+				httpError(w, r, http.StatusForbidden, "cross-origin request rejected")
+				return
+			}
+		} else if site := r.Header.Get("Sec-Fetch-Site"); site == "cross-site" || site == "same-site" {
+			slog.Warn("cross-site request rejected", "sec_fetch_site", site, "path", r.URL.Path, "req_id", middleware.GetReqID(r.Context()))
+			httpError(w, r, http.StatusForbidden, "cross-site request rejected")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestHost is the host the browser talked to; nginx passes it through as Host.
+func requestHost(r *http.Request) string {
+	if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+		return h
+	}
+	return r.Host
+}
+
+// deprecatedGET wraps a mutation so it can still be reached with the old GET
+// route while clients move to POST.
+func deprecatedGET(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Prefetchers and link previews announce themselves; never let them act.
+		if r.Header.Get("Sec-Purpose") != "" || r.Header.Get("Purpose") == "prefetch" || r.Header.Get("X-Moz") == "prefetch" {
+			httpError(w, r, http.StatusForbidden, "prefetch not allowed")
+			return
+		}
+		slog.Warn("deprecated GET mutation", "path", r.URL.Path, "ua", r.UserAgent(), "req_id", middleware.GetReqID(r.Context()))
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Warning", `299 - "GET is deprecated for this endpoint; use POST"`)
+		h(w, r)
+	}
+}
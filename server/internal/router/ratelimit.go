@@ -0,0 +1,280 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"relaypanel/internal/auth"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RateLimit allows N requests per Per, in bursts of up to N. The zero value
+// means no limit.
+type RateLimit struct {
+	N   int
+	Per time.Duration
+}
+
+// ParseRateLimit reads "N/duration" (e.g. "6/1m") or "off".
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "off" || s == "0" {
+		return RateLimit{}, nil
+	}
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q: want N/duration, e.g. 6/1m", s)
+	}
+	l := RateLimit{}
+	var err error
+	if l.N, err = strconv.Atoi(n); err != nil || l.N < 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: bad count", s)
+	}
+	if l.Per, err = time.ParseDuration(per); err != nil || l.Per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: bad duration", s)
+	}
+	return l, nil
+}
+
+func (l RateLimit) String() string {
+	if l.Off() {
+		return "off"
+	}
+	return strconv.Itoa(l.N) + "/" + l.Per.String()
+}
+
+func (l RateLimit) Off() bool { return l.N == 0 || l.Per == 0 }
+
+// RateLimits configures the router's limits. Client caps every mutating
+// request from one caller; Resources caps one caller's requests to a single
+// relay, the door or the TV, keyed by "relay", "door" and "tv"; Shared caps
+// everyone's requests to one of them together, so many callers can't wear a
+// relay out faster than one.
+type RateLimits struct {
+	Client    RateLimit
+	Resources map[string]RateLimit
+	Shared    map[string]RateLimit
+}
+
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Client: RateLimit{N: 120, Per: time.Minute},
+		Resources: map[string]RateLimit{
+			"relay": {N: 20, Per: time.Minute},
+			"door":  {N: 6, Per: time.Minute},
+			"tv":    {N: 90, Per: time.Minute},
+		},
+		Shared: map[string]RateLimit{
+			"relay": {N: 30, Per: time.Minute},
+			"door":  {N: 10, Per: time.Minute},
+			"tv":    {N: 120, Per: time.Minute},
+		},
+	}
+}
+
+// SetResources parses "door=6/1m,relay=off" into l.Resources, keeping
+// resources that aren't mentioned.
+func (l *RateLimits) SetResources(s string) error {
+	if l.Resources == nil {
+		l.Resources = make(map[string]RateLimit)
+	}
+	return parseResourceLimits(l.Resources, s)
+}
+
+// SetShared is SetResources for l.Shared.
+func (l *RateLimits) SetShared(s string) error {
+	if l.Shared == nil {
+		l.Shared = make(map[string]RateLimit)
+	}
+	return parseResourceLimits(l.Shared, s)
+}
+
+func parseResourceLimits(dst map[string]RateLimit, s string) error {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(part, "=")
+		if !ok {
+			return errors.New("want resource=N/duration, e.g. door=6/1m")
+		}
+		switch name {
+		case "relay", "door", "tv":
+		default:
+			return fmt.Errorf("unknown resource %q (relay, door, tv)", name)
+		}
+		rl, err := ParseRateLimit(spec)
+		if err != nil {
+			return err
+		}
+		dst[name] = rl
+	}
+	return nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	limits RateLimits
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*bucket)}
+}
+
+// keyLimit is a bucket and the limit that fills it.
+type keyLimit struct {
+	key   string
+	limit RateLimit
+}
+
+// allow takes a token from key's bucket, or says how long until one is free.
+func (rl *rateLimiter) allow(key string, l RateLimit) (bool, time.Duration) {
+	return rl.allowAll(keyLimit{key, l})
+}
+
+// allowAll takes a token from every bucket, or from none of them and says
+// how long until all have one free.
+func (rl *rateLimiter) allowAll(keys ...keyLimit) (bool, time.Duration) {
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.pruneLocked(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, 0, len(keys))
+	for _, k := range keys {
+		l := k.limit
+		if l.Off() {
+			continue
+		}
+		perToken := l.Per / time.Duration(l.N)
+		b, ok := rl.buckets[k.key]
+		if !ok {
+			b = &bucket{tokens: float64(l.N), last: now}
+			rl.buckets[k.key] = b
+		}
+		b.tokens = math.Min(float64(l.N), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+		b.last = now
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)*float64(perToken)))
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// allowResource takes a token for resource, such as "relay:3", from the
+// caller's bucket and the one everyone shares.
+func (rl *rateLimiter) allowResource(r *http.Request, resource string) (bool, time.Duration) {
+	kind, _, _ := strings.Cut(resource, ":")
+	return rl.allowAll(
+		keyLimit{callerKey(r) + "\x00" + resource, rl.limits.Resources[kind]},
+		// callers are never empty, so this can't collide with one of theirs
+		keyLimit{"\x00" + resource, rl.limits.Shared[kind]},
+	)
+}
+
+// pruneLocked forgets buckets that have been idle long enough to be full
+// again, at most once a minute.
+func (rl *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(rl.pruned) < time.Minute {
+		return
+	}
+	rl.pruned = now
+	longest := rl.limits.Client.Per
+	for _, l := range rl.limits.Resources {
+		longest = max(longest, l.Per)
+	}
+	for _, l := range rl.limits.Shared {
+		longest = max(longest, l.Per)
+	}
+	for k, b := range rl.buckets {
+		if now.Sub(b.last) > longest {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+// callerKey identifies who a request is from: the user or token, or the
+// client address for guests.
+func callerKey(r *http.Request) string {
+	if a := actor(r); a != "" {
+		return a
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientLimit applies the per-caller limit to every mutating request.
+func (rl *rateLimiter) clientLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := rl.allow(callerKey(r), rl.limits.Client); !ok {
+			rateLimited(w, r, "client", wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limit applies the per-resource limit; "{id}" in resource is replaced with
+// the route's id parameter, as in requireScope.
+func (rl *rateLimiter) limit(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := strings.ReplaceAll(resource, "{id}", chi.URLParam(r, "id"))
+			if ok, wait := rl.allowResource(r, res); !ok {
+				rateLimited(w, r, res, wait)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rateLimited(w http.ResponseWriter, r *http.Request, resource string, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	slog.Warn("rate limited", "actor", callerKey(r), "resource", resource, "retry_after", secs, "path", r.URL.Path, "req_id", middleware.GetReqID(r.Context()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, r, http.StatusTooManyRequests, "rate_limited", "too many requests for "+resource+"; retry in "+strconv.Itoa(secs)+"s")
+}
+
+// scopeResource is the rate-limited resource behind a command's scope, or ""
+// if the command isn't limited per resource.
+func scopeResource(scope string) string {
+	switch {
+	case scope == auth.ScopeDoorBuzz:
+		return "door"
+	case scope == auth.ScopeTVControl:
+		return "tv"
+	case strings.HasPrefix(scope, auth.ScopeRelayWrite+":"):
+		return "relay:" + strings.TrimPrefix(scope, auth.ScopeRelayWrite+":")
+	}
+	return ""
+}
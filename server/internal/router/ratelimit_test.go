@@ -0,0 +1,45 @@
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+)
+
+func TestSharedResourceLimit(t *testing.T) {
+	rl := newRateLimiter(RateLimits{
+		Resources: map[string]RateLimit{"relay": {N: 2, Per: time.Minute}},
+		Shared:    map[string]RateLimit{"relay": {N: 3, Per: time.Minute}},
+	})
+	as := func(name string) func(resource string) bool {
+		r := httptest.NewRequest("POST", "/relays/3/toggle", nil)
+		r = r.WithContext(auth.WithUser(r.Context(), &db.User{Username: name}))
+		return func(resource string) bool {
+			ok, _ := rl.allowResource(r, resource)
+			return ok
+		}
+	}
+	alice, bob := as("alice"), as("bob")
+
+	steps := []struct {
+		who      func(string) bool
+		name     string
+		resource string
+		want     bool
+	}{
+		{alice, "alice", "relay:3", true},
+		{alice, "alice", "relay:3", true},
+		{alice, "alice", "relay:3", false}, // her own limit
+		{bob, "bob", "relay:3", true},
+		{bob, "bob", "relay:3", false}, // everyone's limit, though bob has a token left
+		{bob, "bob", "relay:4", true},  // another relay
+	}
+	for i, s := range steps {
+		if got := s.who(s.resource); got != s.want {
+			t.Fatalf("step %d: %s on %s allowed = %v, want %v", i+1, s.name, s.resource, got, s.want)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"time"
@@ -11,8 +13,15 @@ import (
 	"log/slog"
 
 	"relaypanel/internal/adb"
+	"relaypanel/internal/auth"
 	"relaypanel/internal/db"
 	"relaypanel/internal/device"
+	"relaypanel/internal/events"
+	"relaypanel/internal/ota"
+	"relaypanel/internal/rules"
+	"relaypanel/internal/scene"
+	"relaypanel/internal/schedule"
+	"relaypanel/internal/timer"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -21,12 +30,38 @@ import (
 type API struct {
 	Devices *device.Manager
 	ADB     *adb.Client
+	OTA     *ota.Updater
+	Events  *events.Hub
+	Scenes  *scene.Runner
+
+	// Scheduler, Timers and Rules are told when schedules, timers or rules
+	// change; nil if they aren't running.
+	Scheduler *schedule.Scheduler
+	Timers    *timer.Timers
+	Rules     *rules.Engine
+
+	// LegacyGET keeps the old GET routes for relay, door and TV actions
+	// working while clients move to POST.
+	LegacyGET bool
+
+	SessionTTL time.Duration
+
+	// IdempotencyTTL is how long Idempotency-Key responses are replayed.
+	IdempotencyTTL time.Duration
+
+	RateLimits RateLimits
+	limiter    *rateLimiter
 }
 
 type SetLabelRequest struct {
 	Label string `json:"label"`
 }
 
+type RebootRequest struct {
+	Mode   string `json:"mode"` // "soft" (default) or "hard"
+	Reason string `json:"reason"`
+}
+
 type statusWriter struct {
 	http.ResponseWriter
 	status int
@@ -46,6 +81,11 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController reach Flush on the real writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func slogHTTP(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sw := &statusWriter{ResponseWriter: w}
@@ -70,7 +110,35 @@ func (a *API) getRelayStatesHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(states)
 }
 
+// RelayStatus is a relay as shown to the requesting user.
+type RelayStatus struct {
+	device.RelayState
+	// ReadOnly is set when the user may see but not switch the relay.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Timer is the relay's running timer, if it has one.
+	Timer *TimerStatus `json:"timer,omitempty"`
+}
+
+// Permissions tells the panel which controls to offer.
+type Permissions struct {
+	Door bool `json:"door"`
+	TV   bool `json:"tv"`
+}
+
+type StatusResponse struct {
+	DeviceStates []device.DeviceState `json:"devices"`
+	RelayStates  []RelayStatus        `json:"relays"`
+	Permissions  Permissions          `json:"permissions"`
+	Confirm      []string             `json:"confirm"`
+}
+
 func (a *API) getStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.status(r))
+}
+
+// status is the state shown to the requesting user.
+func (a *API) status(r *http.Request) StatusResponse {
 	states := a.Devices.RelayStates()
 	devs := []device.DeviceState{
 		{Name: "relays", State: "disconnected"},
@@ -82,50 +150,116 @@ func (a *API) getStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if a.Devices.GetDevice("buzzer") != nil {
 		devs[1].State = "connected"
 	}
-	resp := struct {
-		DeviceStates []device.DeviceState `json:"devices"`
-		RelayStates  []device.RelayState  `json:"relays"`
-	}{
+	for i := range devs {
+		devs[i].Telemetry = a.Devices.Telemetry(devs[i].Name)
+	}
+
+	can := func(scope string) bool {
+		ok, err := auth.Allowed(r.Context(), scope)
+		if err != nil {
+			slog.Error("permission lookup failed", "err", err)
+		}
+		return ok
+	}
+	timers := relayTimers(r)
+	relays := make([]RelayStatus, len(states))
+	for i, st := range states {
+		relays[i] = RelayStatus{RelayState: st, ReadOnly: !can(auth.RelayScope(strconv.Itoa(i + 1))), Timer: timers[i+1]}
+	}
+	return StatusResponse{
 		DeviceStates: devs,
-		RelayStates:  states,
+		RelayStates:  relays,
+		Permissions: Permissions{
+			Door: can(auth.ScopeDoorBuzz),
+			TV:   can(auth.ScopeTVControl),
+		},
+		Confirm: guardedActions(r),
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
 }
 
 func (a *API) toggleRelayHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if err := a.Devices.ToggleRelay(id); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	if !a.confirm(w, r, auth.RelayAction(id)) {
+		return
+	}
+	err := a.Devices.ToggleRelayAs(id, origin(r, "relay.toggle"))
+	a.audit(r, "relay.toggle", "relay:"+id, nil, err)
+	if err != nil {
+		writeErr(w, r, err)
 		return
 	}
 	a.getRelayStatesHandler(w, r)
 }
 
+// buzzDoor buzzes the door if the door policy lets a signed-in caller.
+func (a *API) buzzDoor(ctx context.Context) error {
+	if err := auth.CheckDoor(ctx, false); err != nil {
+		return err
+	}
+	return a.Devices.BuzzDoor()
+}
+
 func (a *API) doorBuzzHandler(w http.ResponseWriter, r *http.Request) {
-	if err := a.Devices.BuzzDoor(); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	if !a.confirm(w, r, auth.ActionDoor) {
+		return
+	}
+	err := a.buzzDoor(r.Context())
+	a.audit(r, "door.buzz", "door", nil, err)
+	if err != nil {
+		writeErr(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+func (a *API) rebootDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var req RebootRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, http.StatusBadRequest, "invalid json")
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "requested via api"
+	}
+
+	var err error
+	switch req.Mode {
+	case "", "soft":
+		err = a.Devices.Reboot(name, req.Reason)
+	case "hard":
+		err = a.Devices.HardReset(name, req.Reason)
+	default:
+		httpError(w, r, http.StatusBadRequest, "invalid mode")
+		return
+	}
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (a *API) setRelayLabelHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if len(id) != 1 || id[0] < '1' || id[0] > '8' {
-		http.Error(w, "invalid relay id", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "invalid relay id")
 		return
 	}
 	idx := int(id[0] - '1')
 
 	var req SetLabelRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "invalid json")
 		return
 	}
 	a.Devices.UpdateLabel(idx, req.Label)
-	if err := db.UpdateRelayLabel(r.Context(), int64(idx+1), req.Label); err != nil {
-		http.Error(w, "failed to update label in database", http.StatusInternalServerError)
+	err := db.UpdateRelayLabel(r.Context(), int64(idx+1), req.Label)
+	a.audit(r, "relay.label", "relay:"+id, map[string]string{"label": req.Label}, err)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to update label in database")
 		return
 	}
 	slog.Info("relay label updated", "relay_index", idx+1, "label", req.Label)
@@ -134,11 +268,13 @@ func (a *API) setRelayLabelHandler(w http.ResponseWriter, r *http.Request) {
 
 func (a *API) tvDo(w http.ResponseWriter, r *http.Request, fn func() error) {
 	if a.ADB == nil {
-		http.Error(w, "adb client not configured", http.StatusServiceUnavailable)
+		httpError(w, r, http.StatusServiceUnavailable, "adb client not configured")
 		return
 	}
-	if err := fn(); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	err := fn()
+	a.audit(r, "tv."+path.Base(r.URL.Path), "tv", nil, err)
+	if err != nil {
+		writeErr(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -210,41 +346,145 @@ func Router(a *API) http.Handler {
 	r.Use(middleware.StripSlashes)
 	r.Use(middleware.RequestID)
 	r.Use(slogHTTP)
+	r.Use(sameOrigin)
+	r.Use(a.requireAuth)
+	r.Use(newIdempotencyStore(a.IdempotencyTTL).middleware)
+	a.limiter = newRateLimiter(a.RateLimits)
+	r.Use(a.limiter.clientLimit)
+
+	// mutate registers a state-changing route as POST, plus the deprecated GET
+	// alias when enabled. scope is what an API token needs to use it, and
+	// also picks the resource it's rate limited as.
+	mutate := func(path, scope string, h http.HandlerFunc) {
+		limit := a.limiter.limit(scopeResource(scope))
+		r.With(requireScope(scope), limit).Post(path, h)
+		if a.LegacyGET {
+			r.With(requireScope(scope), limit).Get(path, deprecatedGET(h))
+		}
+	}
+	read := r.With(requireScope(auth.ScopeRelayRead))
+	devicesRead := r.With(requireScope(auth.ScopeDevicesRead))
+	devicesWrite := r.With(requireScope(auth.ScopeDevicesWrite))
+	sensorsRead := r.With(requireScope(auth.ScopeSensorsRead))
+	sensorsWrite := r.With(requireScope(auth.ScopeSensorsWrite))
+	scenesRead := r.With(requireScope(auth.ScopeScenesRead))
+	scenesWrite := r.With(requireScope(auth.ScopeScenesWrite))
+	schedulesRead := r.With(requireScope(auth.ScopeSchedulesRead))
+	schedulesWrite := r.With(requireScope(auth.ScopeSchedulesWrite))
+	rulesRead := r.With(requireScope(auth.ScopeRulesRead))
+	rulesWrite := r.With(requireScope(auth.ScopeRulesWrite))
 
-	r.Get("/status", a.getStatusHandler)
-	r.Get("/relay/{id}", a.toggleRelayHandler)
-	r.Get("/relay/states", a.getRelayStatesHandler)
-	r.Post("/relay/setLabel/{id}", a.setRelayLabelHandler)
-	r.Get("/door/buzz", a.doorBuzzHandler)
-
-	r.Get("/tv/volume_up", a.tvVolumeUpHandler)
-	r.Get("/tv/volume_down", a.tvVolumeDownHandler)
-	r.Get("/tv/power", a.tvPowerHandler)
-	r.Get("/tv/home", a.tvHomeHandler)
-	r.Get("/tv/back", a.tvBackHandler)
-	r.Get("/tv/mic_mute", a.tvMicMuteHandler)
-	r.Get("/tv/media_play_pause", a.tvMediaPlayPauseHandler)
-	r.Get("/tv/media_next", a.tvMediaNextHandler)
-	r.Get("/tv/media_prev", a.tvMediaPrevHandler)
-	r.Get("/tv/media_stop", a.tvMediaStopHandler)
-
-	r.Get("/tv/dpad_up", a.tvDpadUpHandler)
-	r.Get("/tv/dpad_down", a.tvDpadDownHandler)
-	r.Get("/tv/dpad_left", a.tvDpadLeftHandler)
-	r.Get("/tv/dpad_right", a.tvDpadRightHandler)
-	r.Get("/tv/dpad_center", a.tvDpadCenterHandler)
-	r.Get("/tv/menu", a.tvMenuHandler)
-	r.Get("/tv/settings", a.tvSettingsHandler)
-	r.Get("/tv/speaker_mute", a.tvSpeakerMuteHandler)
-	r.Get("/tv/input_source", a.tvInputSourceHandler)
-	r.Get("/tv/favourite", a.tvFavouriteHandler)
+	r.Get("/login", a.loginPageHandler)
+	r.Post("/login", a.loginHandler)
+	r.Post("/logout", a.logoutHandler)
 
+	r.With(sessionOnly).Get("/tokens", a.listTokensHandler)
+	r.With(sessionOnly).Post("/tokens", a.createTokenHandler)
+	r.With(sessionOnly).Delete("/tokens/{id}", a.revokeTokenHandler)
+	r.With(adminOnly).Get("/users", a.listUsersHandler)
+	r.With(adminOnly).Put("/users/{id}/permissions", a.setUserPermissionsHandler)
+	r.With(adminOnly).Get("/confirmations", a.listConfirmationsHandler)
+	r.With(adminOnly).Get("/audit", a.listAuditHandler)
+
+	read.Get("/status", a.getStatusHandler)
+	read.Get("/events", a.eventsHandler)
+	read.Get("/ws", a.wsHandler)
+	mutate("/relay/{id}", auth.RelayScope("{id}"), a.toggleRelayHandler)
+	read.Get("/relay/states", a.getRelayStatesHandler)
+	r.Post("/relays", a.setRelaysHandler)
+	read.Get("/relay/timeline", a.relayTimelineHandler)
+	read.Get("/relay/{id}/history", a.relayHistoryHandler)
+	r.With(requireScope(auth.RelayScope("{id}")), a.limiter.limit("relay:{id}")).Post("/relay/{id}/timer", a.startRelayTimerHandler)
+	read.Get("/timers", a.listRelayTimersHandler)
+	// needs relay:write:<n> for the timer's relay
+	r.Delete("/timers/{id}", a.cancelRelayTimerHandler)
+	r.With(requireScope(auth.RelayScope("{id}"))).Post("/relay/setLabel/{id}", a.setRelayLabelHandler)
+	mutate("/door/buzz", auth.ScopeDoorBuzz, a.doorBuzzHandler)
+	doorBuzz := r.With(requireScope(auth.ScopeDoorBuzz))
+	doorBuzz.Get("/door/guest-links", a.listGuestLinksHandler)
+	doorBuzz.Get("/door/policy", a.getDoorPolicyHandler)
+	r.With(adminOnly).Put("/door/policy", a.setDoorPolicyHandler)
+	doorBuzz.Post("/door/guest-links", a.createGuestLinkHandler)
+	doorBuzz.Delete("/door/guest-links/{id}", a.revokeGuestLinkHandler)
+	doorBuzz.Get("/door/guest-links/{id}/uses", a.guestLinkUsesHandler)
+	r.Get("/guest/{token}", a.guestPageHandler)
+	r.With(a.limiter.limit("door")).Post("/guest/{token}/buzz", a.guestBuzzHandler)
+
+	mutate("/tv/volume_up", auth.ScopeTVControl, a.tvVolumeUpHandler)
+	mutate("/tv/volume_down", auth.ScopeTVControl, a.tvVolumeDownHandler)
+	mutate("/tv/power", auth.ScopeTVControl, a.tvPowerHandler)
+	mutate("/tv/home", auth.ScopeTVControl, a.tvHomeHandler)
+	mutate("/tv/back", auth.ScopeTVControl, a.tvBackHandler)
+	mutate("/tv/mic_mute", auth.ScopeTVControl, a.tvMicMuteHandler)
+	mutate("/tv/media_play_pause", auth.ScopeTVControl, a.tvMediaPlayPauseHandler)
+	mutate("/tv/media_next", auth.ScopeTVControl, a.tvMediaNextHandler)
+	mutate("/tv/media_prev", auth.ScopeTVControl, a.tvMediaPrevHandler)
+	mutate("/tv/media_stop", auth.ScopeTVControl, a.tvMediaStopHandler)
+
+	mutate("/tv/dpad_up", auth.ScopeTVControl, a.tvDpadUpHandler)
+	mutate("/tv/dpad_down", auth.ScopeTVControl, a.tvDpadDownHandler)
+	mutate("/tv/dpad_left", auth.ScopeTVControl, a.tvDpadLeftHandler)
+	mutate("/tv/dpad_right", auth.ScopeTVControl, a.tvDpadRightHandler)
+	mutate("/tv/dpad_center", auth.ScopeTVControl, a.tvDpadCenterHandler)
+	mutate("/tv/menu", auth.ScopeTVControl, a.tvMenuHandler)
+	mutate("/tv/settings", auth.ScopeTVControl, a.tvSettingsHandler)
+	mutate("/tv/speaker_mute", auth.ScopeTVControl, a.tvSpeakerMuteHandler)
+	mutate("/tv/input_source", auth.ScopeTVControl, a.tvInputSourceHandler)
+	mutate("/tv/favourite", auth.ScopeTVControl, a.tvFavouriteHandler)
+
+	devicesRead.Get("/devices", a.listDevicesHandler)
+	devicesWrite.Post("/devices/{name}/reboot", a.rebootDeviceHandler)
+	devicesWrite.Put("/devices/{name}/ota", a.setDeviceOTAHandler)
+	devicesWrite.Post("/devices/{name}/ota", a.startOTAHandler)
+	devicesRead.Get("/devices/{name}/firmware", a.firmwareHistoryHandler)
+	devicesRead.Get("/devices/{name}/telemetry", a.telemetryHistoryHandler)
+	sensorsRead.Get("/sensors", a.listSensorsHandler)
+	sensorsWrite.Put("/sensors/{id}", a.updateSensorHandler)
+	sensorsRead.Get("/sensors/{id}/history", a.sensorHistoryHandler)
+
+	scenesRead.Get("/scenes", a.listScenesHandler)
+	scenesWrite.Post("/scenes", a.createSceneHandler)
+	scenesRead.Get("/scenes/{id}", a.getSceneHandler)
+	scenesWrite.Put("/scenes/{id}", a.updateSceneHandler)
+	scenesWrite.Delete("/scenes/{id}", a.deleteSceneHandler)
+	// also needs the scope of every command in the scene
+	scenesRead.Post("/scenes/{id}/run", a.runSceneHandler)
+	scenesRead.Get("/scene-runs", a.listSceneRunsHandler)
+	scenesRead.Get("/scene-runs/{id}", a.getSceneRunHandler)
+
+	schedulesRead.Get("/schedules", a.listSchedulesHandler)
+	schedulesWrite.Post("/schedules", a.createScheduleHandler)
+	schedulesRead.Get("/schedules/preview", a.previewScheduleHandler)
+	schedulesRead.Get("/schedules/sun", a.sunTimesHandler)
+	schedulesRead.Get("/schedules/{id}", a.getScheduleHandler)
+	schedulesWrite.Put("/schedules/{id}", a.updateScheduleHandler)
+	schedulesWrite.Delete("/schedules/{id}", a.deleteScheduleHandler)
+
+	rulesRead.Get("/rules", a.listRulesHandler)
+	rulesWrite.Post("/rules", a.createRuleHandler)
+	rulesRead.Get("/rules/{id}", a.getRuleHandler)
+	rulesWrite.Put("/rules/{id}", a.updateRuleHandler)
+	rulesWrite.Delete("/rules/{id}", a.deleteRuleHandler)
+
+	devicesRead.Get("/firmware", a.listFirmwareHandler)
+	devicesWrite.Post("/firmware", a.uploadFirmwareHandler)
+	devicesRead.Get("/ota", a.listOTAJobsHandler)
+	devicesRead.Get("/ota/{id}", a.getOTAJobHandler)
+
+	r.Route("/api/v1", a.v1Routes)
+
+	r.Handle("/*", http.FileServer(http.Dir(staticDir())))
+	return r
+}
+
+func staticDir() string {
 	exePath, _ := os.Executable()
 	exeDir := filepath.Dir(exePath)
-	staticDir := filepath.Clean(filepath.Join(exeDir, "..", "static"))
+	return filepath.Clean(filepath.Join(exeDir, "..", "static"))
+}
 
-	r.Handle("/", http.FileServer(http.Dir(staticDir)))
-	return r
+func (a *API) staticFile(name string) string {
+	return filepath.Join(staticDir(), name)
 }
 
 func FormatRelayStatus(states []device.RelayState) (joined string, onCount int) {
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/rules"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type RuleRequest struct {
+	Name       string            `json:"name"`
+	Trigger    db.RuleTrigger    `json:"trigger"`
+	Conditions db.RuleConditions `json:"conditions"`
+	Actions    db.Targets        `json:"actions"`
+	Enabled    *bool             `json:"enabled"` // default true
+	CooldownS  int64             `json:"cooldown_s"`
+}
+
+func ruleID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid rule id")
+		return 0, false
+	}
+	return id, true
+}
+
+// reloadRules tells the rules engine, if one is running, about a change.
+func (a *API) reloadRules() {
+	if a.Rules != nil {
+		a.Rules.Reload()
+	}
+}
+
+// decodeRule reads a create or update request into ru and checks it and the
+// caller's right to store every one of its actions.
+func decodeRule(w http.ResponseWriter, r *http.Request, ru *db.Rule) bool {
+	var req RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return false
+	}
+	ru.Name, ru.Trigger, ru.Conditions, ru.Actions = req.Name, req.Trigger, req.Conditions, req.Actions
+	ru.CooldownS = req.CooldownS
+	ru.Enabled = req.Enabled == nil || *req.Enabled
+	if ru.Conditions == nil {
+		ru.Conditions = db.RuleConditions{}
+	}
+	if err := rules.Validate(ru); err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return false
+	}
+	for i := range ru.Actions {
+		if err := validTarget(r.Context(), &ru.Actions[i]); err != nil {
+			httpError(w, r, http.StatusBadRequest, fmt.Sprintf("action %d: %v", i+1, err))
+			return false
+		}
+	}
+	for _, t := range ru.Actions {
+		if !allowedTarget(w, r, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *API) listRulesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := db.ListRules(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list rules")
+		return
+	}
+	if list == nil {
+		list = []db.Rule{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *API) getRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+	ru, err := db.GetRule(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ru)
+}
+
+func (a *API) createRuleHandler(w http.ResponseWriter, r *http.Request) {
+	ru := db.Rule{CreatedBy: actor(r)}
+	if !decodeRule(w, r, &ru) {
+		return
+	}
+	if err := db.CreateRule(r.Context(), &ru); errors.Is(err, db.ErrConflict) {
+		httpError(w, r, http.StatusConflict, "a rule with that name already exists")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to create rule")
+		return
+	}
+	a.reloadRules()
+	writeJSON(w, http.StatusCreated, ru)
+}
+
+func (a *API) updateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+	ru, err := db.GetRule(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	if !decodeRule(w, r, ru) {
+		return
+	}
+	if err := db.UpdateRule(r.Context(), ru); errors.Is(err, db.ErrConflict) {
+		httpError(w, r, http.StatusConflict, "a rule with that name already exists")
+		return
+	} else if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	a.reloadRules()
+	writeJSON(w, http.StatusOK, ru)
+}
+
+func (a *API) deleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(w, r)
+	if !ok {
+		return
+	}
+	if err := db.DeleteRule(r.Context(), id); err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	a.reloadRules()
+	w.WriteHeader(http.StatusNoContent)
+}
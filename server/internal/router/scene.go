@@ -0,0 +1,217 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+	"relaypanel/internal/scene"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SceneRequest struct {
+	Name  string         `json:"name"`
+	Steps []db.SceneStep `json:"steps"`
+}
+
+func sceneID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid scene id")
+		return 0, false
+	}
+	return id, true
+}
+
+// loadScene fetches the scene named by {id}, writing 404 if there's none.
+func loadScene(w http.ResponseWriter, r *http.Request) (*db.Scene, bool) {
+	id, ok := sceneID(w, r)
+	if !ok {
+		return nil, false
+	}
+	sc, err := db.GetScene(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown scene")
+		return nil, false
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load scene")
+		return nil, false
+	}
+	return sc, true
+}
+
+// decodeScene reads a create or update request and checks it. The caller
+// needs the scope of every command in the steps, so scenes:write can't be
+// used to store commands that someone else's run would carry out.
+func decodeScene(w http.ResponseWriter, r *http.Request) (SceneRequest, bool) {
+	var req SceneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return req, false
+	}
+	if err := scene.Validate(req.Name, req.Steps); err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	if !allowedScopes(w, r, sceneChecks(&db.Scene{Steps: req.Steps})) {
+		return req, false
+	}
+	return req, true
+}
+
+func (a *API) listScenesHandler(w http.ResponseWriter, r *http.Request) {
+	scenes, err := db.ListScenes(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list scenes")
+		return
+	}
+	if scenes == nil {
+		scenes = []db.Scene{}
+	}
+	writeJSON(w, http.StatusOK, scenes)
+}
+
+func (a *API) getSceneHandler(w http.ResponseWriter, r *http.Request) {
+	if sc, ok := loadScene(w, r); ok {
+		writeJSON(w, http.StatusOK, sc)
+	}
+}
+
+func (a *API) createSceneHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeScene(w, r)
+	if !ok {
+		return
+	}
+	sc, err := db.CreateScene(r.Context(), req.Name, req.Steps)
+	if errors.Is(err, db.ErrConflict) {
+		httpError(w, r, http.StatusConflict, "a scene with that name already exists")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to create scene")
+		return
+	}
+	writeJSON(w, http.StatusCreated, sc)
+}
+
+func (a *API) updateSceneHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := sceneID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeScene(w, r)
+	if !ok {
+		return
+	}
+	err := db.UpdateScene(r.Context(), id, req.Name, req.Steps)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		httpError(w, r, http.StatusNotFound, "unknown scene")
+		return
+	case errors.Is(err, db.ErrConflict):
+		httpError(w, r, http.StatusConflict, "a scene with that name already exists")
+		return
+	case err != nil:
+		httpError(w, r, http.StatusInternalServerError, "failed to update scene")
+		return
+	}
+	if sc, ok := loadScene(w, r); ok {
+		writeJSON(w, http.StatusOK, sc)
+	}
+}
+
+func (a *API) deleteSceneHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := sceneID(w, r)
+	if !ok {
+		return
+	}
+	err := db.DeleteScene(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown scene")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to delete scene")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sceneChecks is what running sc asks of the caller: the scope, guard and
+// rate limit of every command in it, as if each were sent on its own.
+func sceneChecks(sc *db.Scene) []commandCheck {
+	var checks []commandCheck
+	for _, st := range sc.Steps {
+		switch st.Type {
+		case scene.StepRelays:
+			_, which, _ := scene.RelayBits(st.States, st.Action)
+			for i := 0; i < 8; i++ {
+				if which&(1<<i) != 0 {
+					checks = append(checks, relayCheck(strconv.Itoa(i+1)))
+				}
+			}
+		case scene.StepTV:
+			checks = append(checks, commandCheck{Scope: auth.ScopeTVControl, Resource: "tv"})
+		case scene.StepDoor:
+			checks = append(checks, commandCheck{Scope: auth.ScopeDoorBuzz, Action: auth.ActionDoor, Resource: "door"})
+		}
+	}
+	return checks
+}
+
+// runSceneHandler starts a scene and answers 202 with the run straight away;
+// GET /scene-runs/{id} reports each step as it finishes. The run isn't cut
+// short if the client goes away, and is audited once it's done.
+func (a *API) runSceneHandler(w http.ResponseWriter, r *http.Request) {
+	sc, ok := loadScene(w, r)
+	if !ok {
+		return
+	}
+	if !a.permit(w, r, sceneChecks(sc)) {
+		return
+	}
+	o := origin(r, "scene:"+sc.Name)
+	e := auditEntry(r)
+	target := "scene:" + strconv.FormatInt(sc.ID, 10)
+	params := map[string]any{"name": sc.Name, "steps": len(sc.Steps)}
+	job := a.Scenes.Start(sc, o, func(job *scene.Job) {
+		recordAudit(context.Background(), e, "scene.run", target, params, scene.Failed(job.Steps))
+	})
+	writeJSON(w, http.StatusAccepted, sceneJob(job))
+}
+
+// sceneJob fills in the error code of each failed step of job.
+func sceneJob(job *scene.Job) *scene.Job {
+	for i := range job.Steps {
+		if err := job.Steps[i].Err; err != nil {
+			_, job.Steps[i].Code = errorStatus(err)
+			job.Steps[i].Error = err.Error()
+		}
+	}
+	return job
+}
+
+func (a *API) listSceneRunsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs := a.Scenes.Jobs()
+	for i := range jobs {
+		sceneJob(&jobs[i])
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (a *API) getSceneRunHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid run id")
+		return
+	}
+	job := a.Scenes.Job(id)
+	if job == nil {
+		httpError(w, r, http.StatusNotFound, "unknown scene run")
+		return
+	}
+	writeJSON(w, http.StatusOK, sceneJob(job))
+}
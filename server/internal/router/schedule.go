@@ -0,0 +1,282 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/schedule"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultPreviewRuns = 5
+	maxPreviewRuns     = 100
+)
+
+type ScheduleRequest struct {
+	Name          string    `json:"name"`
+	Cron          string    `json:"cron"`
+	TimeZone      string    `json:"time_zone"`
+	Target        db.Target `json:"target"`
+	Enabled       *bool     `json:"enabled"` // default true
+	OnMissed      string    `json:"on_missed"`
+	MissedWindowS int64     `json:"missed_window_s"`
+}
+
+// ScheduleResponse is a schedule with its next few runs in its time zone.
+type ScheduleResponse struct {
+	db.Schedule
+	Upcoming []time.Time `json:"upcoming"`
+}
+
+type SchedulePreview struct {
+	Cron     string      `json:"cron"`
+	TimeZone string      `json:"time_zone"`
+	Runs     []time.Time `json:"runs"`
+}
+
+func scheduleID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid schedule id")
+		return 0, false
+	}
+	return id, true
+}
+
+func scheduleResponse(s db.Schedule, n int) ScheduleResponse {
+	res := ScheduleResponse{Schedule: s, Upcoming: []time.Time{}}
+	if spec, err := schedule.ParseSpec(s.Cron, s.TimeZone); err == nil && s.Enabled {
+		res.Upcoming = spec.Upcoming(time.Now(), n)
+	}
+	return res
+}
+
+// reloadSchedules tells the scheduler, if one is running, about a change.
+func (a *API) reloadSchedules() {
+	if a.Scheduler != nil {
+		a.Scheduler.Reload()
+	}
+}
+
+// decodeSchedule reads a create or update request into s, checks it and
+// the caller's right to store its target, and works out the next run.
+func decodeSchedule(w http.ResponseWriter, r *http.Request, s *db.Schedule) bool {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return false
+	}
+	if req.Name == "" {
+		httpError(w, r, http.StatusBadRequest, "name is required")
+		return false
+	}
+	spec, err := schedule.ParseSpec(req.Cron, req.TimeZone)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return false
+	}
+	switch req.OnMissed {
+	case "":
+		req.OnMissed = db.MissedSkip
+	case db.MissedSkip, db.MissedRunOnce:
+	default:
+		httpError(w, r, http.StatusBadRequest, `on_missed must be "skip" or "run_once"`)
+		return false
+	}
+	if req.MissedWindowS < 0 {
+		httpError(w, r, http.StatusBadRequest, "invalid missed_window_s")
+		return false
+	}
+	if err := validTarget(r.Context(), &req.Target); err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if !allowedTarget(w, r, req.Target) {
+		return false
+	}
+
+	s.Name, s.Cron, s.TimeZone, s.Target = req.Name, req.Cron, req.TimeZone, req.Target
+	s.OnMissed, s.MissedWindowS = req.OnMissed, req.MissedWindowS
+	s.Enabled = req.Enabled == nil || *req.Enabled
+	s.NextRunAt = nil
+	if s.Enabled {
+		s.NextRunAt = spec.Next(time.Now())
+	}
+	return true
+}
+
+func (a *API) listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := db.ListSchedules(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list schedules")
+		return
+	}
+	out := make([]ScheduleResponse, 0, len(list))
+	for _, s := range list {
+		out = append(out, scheduleResponse(s, 1))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// getScheduleHandler returns a schedule with its next ?count= runs (5 by
+// default).
+func (a *API) getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := scheduleID(w, r)
+	if !ok {
+		return
+	}
+	n, ok := previewCount(w, r)
+	if !ok {
+		return
+	}
+	s, err := db.GetSchedule(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, scheduleResponse(*s, n))
+}
+
+func (a *API) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	s := db.Schedule{CreatedBy: actor(r)}
+	if !decodeSchedule(w, r, &s) {
+		return
+	}
+	if err := db.CreateSchedule(r.Context(), &s); errors.Is(err, db.ErrConflict) {
+		httpError(w, r, http.StatusConflict, "a schedule with that name already exists")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to create schedule")
+		return
+	}
+	a.reloadSchedules()
+	writeJSON(w, http.StatusCreated, scheduleResponse(s, defaultPreviewRuns))
+}
+
+func (a *API) updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := scheduleID(w, r)
+	if !ok {
+		return
+	}
+	s, err := db.GetSchedule(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	if !decodeSchedule(w, r, s) {
+		return
+	}
+	if err := db.UpdateSchedule(r.Context(), s); errors.Is(err, db.ErrConflict) {
+		httpError(w, r, http.StatusConflict, "a schedule with that name already exists")
+		return
+	} else if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	a.reloadSchedules()
+	writeJSON(w, http.StatusOK, scheduleResponse(*s, defaultPreviewRuns))
+}
+
+func (a *API) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := scheduleID(w, r)
+	if !ok {
+		return
+	}
+	if err := db.DeleteSchedule(r.Context(), id); err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	a.reloadSchedules()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// previewScheduleHandler lists the next ?count= runs of ?cron= in
+// ?time_zone=, for trying out an expression before saving it.
+func (a *API) previewScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	n, ok := previewCount(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	spec, err := schedule.ParseSpec(q.Get("cron"), q.Get("time_zone"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, SchedulePreview{
+		Cron:     q.Get("cron"),
+		TimeZone: q.Get("time_zone"),
+		Runs:     spec.Upcoming(time.Now(), n),
+	})
+}
+
+func previewCount(w http.ResponseWriter, r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("count")
+	if s == "" {
+		return defaultPreviewRuns, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxPreviewRuns {
+		httpError(w, r, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(maxPreviewRuns))
+		return 0, false
+	}
+	return n, true
+}
+
+// SunTimes are the sun events of one day; an event that doesn't happen
+// that day, near the poles, is null.
+type SunTimes struct {
+	Date    string     `json:"date"`
+	Dawn    *time.Time `json:"dawn"`
+	Sunrise *time.Time `json:"sunrise"`
+	Sunset  *time.Time `json:"sunset"`
+	Dusk    *time.Time `json:"dusk"`
+}
+
+// sunTimesHandler serves the sun events sun schedules follow on ?date=
+// (YYYY-MM-DD, default today) in ?time_zone=, at the server's --location.
+func (a *API) sunTimesHandler(w http.ResponseWriter, r *http.Request) {
+	at, ok := schedule.Location()
+	if !ok {
+		httpError(w, r, http.StatusNotFound, "no location configured; start the server with --location=lat,lon")
+		return
+	}
+	q := r.URL.Query()
+	loc := time.Local
+	if tz := q.Get("time_zone"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			httpError(w, r, http.StatusBadRequest, "unknown time zone")
+			return
+		}
+	}
+	day := time.Now().In(loc)
+	if s := q.Get("date"); s != "" {
+		d, err := time.ParseInLocation(time.DateOnly, s, loc)
+		if err != nil {
+			httpError(w, r, http.StatusBadRequest, "invalid date")
+			return
+		}
+		day = d.Add(12 * time.Hour)
+	}
+	event := func(name string) *time.Time {
+		if t, ok := schedule.SunEvent(name, day, at); ok {
+			t = t.Truncate(time.Minute)
+			return &t
+		}
+		return nil
+	}
+	writeJSON(w, http.StatusOK, SunTimes{
+		Date:    day.Format(time.DateOnly),
+		Dawn:    event(schedule.Dawn),
+		Sunrise: event(schedule.Sunrise),
+		Sunset:  event(schedule.Sunset),
+		Dusk:    event(schedule.Dusk),
+	})
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultSensorWindow = 24 * time.Hour
+	defaultSensorLimit  = 5000
+	maxSensorLimit      = 50000
+)
+
+var sensorKinds = map[string]bool{
+	"generic":     true,
+	"temperature": true,
+	"contact":     true,
+	"motion":      true,
+	"analog":      true,
+	"touch":       true,
+}
+
+type UpdateSensorRequest struct {
+	Label *string `json:"label"`
+	Kind  *string `json:"kind"`
+	Unit  *string `json:"unit"`
+}
+
+func sensorID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid sensor id")
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *API) listSensorsHandler(w http.ResponseWriter, r *http.Request) {
+	sensors, err := db.ListSensors(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list sensors")
+		return
+	}
+	if sensors == nil {
+		sensors = []db.Sensor{}
+	}
+	writeJSON(w, http.StatusOK, sensors)
+}
+
+func (a *API) updateSensorHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := sensorID(w, r)
+	if !ok {
+		return
+	}
+	s, err := db.GetSensor(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown sensor")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load sensor")
+		return
+	}
+	var req UpdateSensorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Label != nil {
+		s.Label = *req.Label
+	}
+	if req.Kind != nil {
+		if !sensorKinds[*req.Kind] {
+			httpError(w, r, http.StatusBadRequest, "invalid sensor kind")
+			return
+		}
+		s.Kind = *req.Kind
+	}
+	if req.Unit != nil {
+		s.Unit = *req.Unit
+	}
+	if err := db.UpdateSensor(r.Context(), id, s.Label, s.Kind, s.Unit); err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to update sensor")
+		return
+	}
+	slog.Info("sensor updated", "sensor", id, "label", s.Label, "kind", s.Kind, "unit", s.Unit)
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *API) sensorHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := sensorID(w, r)
+	if !ok {
+		return
+	}
+	from, to, err := parseTimeRange(r, defaultSensorWindow)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	limit := defaultSensorLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			httpError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(limit, maxSensorLimit)
+	}
+	readings, err := db.ListSensorReadings(r.Context(), id, from, to, limit)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list sensor readings")
+		return
+	}
+	if readings == nil {
+		readings = []db.SensorReading{}
+	}
+	writeJSON(w, http.StatusOK, readings)
+}
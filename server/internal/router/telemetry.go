@@ -0,0 +1,59 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultTelemetryWindow = 24 * time.Hour
+	defaultTelemetryLimit  = 2880
+	maxTelemetryLimit      = 20000
+)
+
+// parseTimeRange reads ?from=&to= as RFC 3339, defaulting to the last window.
+func parseTimeRange(r *http.Request, window time.Duration) (from, to time.Time, err error) {
+	to = time.Now()
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			return
+		}
+	}
+	from = to.Add(-window)
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (a *API) telemetryHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r, defaultTelemetryWindow)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	limit := defaultTelemetryLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			httpError(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(limit, maxTelemetryLimit)
+	}
+	samples, err := db.ListTelemetry(r.Context(), chi.URLParam(r, "name"), from, to, limit)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list telemetry")
+		return
+	}
+	if samples == nil {
+		samples = []db.TelemetrySample{}
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
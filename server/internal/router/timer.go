@@ -0,0 +1,194 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+	"relaypanel/internal/events"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxTimerDuration = 7 * 24 * time.Hour
+
+// RelayTimerRequest switches a relay now and back after Duration: "on" as
+// EndAction (default "off") means off now, on later.
+type RelayTimerRequest struct {
+	Duration  string `json:"duration"` // Go duration, e.g. "30m"
+	EndAction string `json:"end_action"`
+}
+
+// TimerStatus is an active timer as shown on its relay.
+type TimerStatus struct {
+	ID         int64     `json:"id"`
+	EndsAt     time.Time `json:"ends_at"`
+	EndAction  string    `json:"end_action"`
+	RemainingS int64     `json:"remaining_s"`
+}
+
+type RelayTimerResponse struct {
+	db.RelayTimer
+	EndAction  string `json:"end_action"`
+	RemainingS int64  `json:"remaining_s"`
+}
+
+func endAction(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func remaining(t time.Time) int64 {
+	return int64(math.Ceil(max(time.Until(t), 0).Seconds()))
+}
+
+func timerResponse(t db.RelayTimer) RelayTimerResponse {
+	return RelayTimerResponse{RelayTimer: t, EndAction: endAction(t.EndState), RemainingS: remaining(t.EndsAt)}
+}
+
+// relayTimers maps relay numbers to their active timers, for /status.
+func relayTimers(r *http.Request) map[int]*TimerStatus {
+	list, err := db.ListActiveRelayTimers(r.Context())
+	if err != nil {
+		slog.Error("failed to list relay timers", "err", err)
+		return nil
+	}
+	out := make(map[int]*TimerStatus, len(list))
+	for _, t := range list {
+		out[t.Relay] = &TimerStatus{ID: t.ID, EndsAt: t.EndsAt, EndAction: endAction(t.EndState), RemainingS: remaining(t.EndsAt)}
+	}
+	return out
+}
+
+// timerChanged wakes the timer loop and tells event subscribers about the
+// relay's timer, t, or nil once it has none.
+func (a *API) timerChanged(relay int, t *db.RelayTimer) {
+	if a.Timers != nil {
+		a.Timers.Reload()
+	}
+	if a.Events == nil {
+		return
+	}
+	c := events.TimerChange{Relay: relay}
+	if t != nil {
+		c.Timer = &events.RelayTimer{ID: t.ID, EndsAt: t.EndsAt, EndAction: endAction(t.EndState)}
+	}
+	a.Events.Publish(events.TypeTimer, c)
+}
+
+// startRelayTimerHandler switches the relay away from the end state now and
+// stores a timer to switch it back. A relay has one timer; a new one
+// replaces it.
+func (a *API) startRelayTimerHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := relayID(w, r)
+	if !ok {
+		return
+	}
+	var req RelayTimerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d < time.Second || d > maxTimerDuration {
+		httpError(w, r, http.StatusBadRequest, "duration must be between 1s and "+maxTimerDuration.String())
+		return
+	}
+	switch req.EndAction {
+	case "":
+		req.EndAction = "off"
+	case "off", "on":
+	default:
+		httpError(w, r, http.StatusBadRequest, `end_action must be "off" or "on"`)
+		return
+	}
+	target := strconv.Itoa(id)
+	if !a.confirm(w, r, auth.RelayAction(target)) {
+		return
+	}
+
+	end := req.EndAction == "on"
+	bit := byte(1) << (id - 1)
+	var mask byte
+	if !end {
+		mask = bit
+	}
+	was := a.Devices.RelayStates()[id-1].State
+	_, err = a.Devices.SetRelays(r.Context(), mask, bit, origin(r, "relay.timer"))
+	a.audit(r, "relay.timer", "relay:"+target, req, err)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	t := db.RelayTimer{Relay: id, EndState: end, EndsAt: time.Now().Add(d), CreatedBy: actor(r)}
+	if err := db.CreateRelayTimer(r.Context(), &t); err != nil {
+		slog.Error("failed to store relay timer", "relay", id, "err", err)
+		// Nothing would switch the relay back, so put it back now if it was
+		// in the end state; its previous timer, if any, is still running.
+		if was == end {
+			if _, err := a.Devices.SetRelays(context.WithoutCancel(r.Context()), mask^bit, bit, origin(r, "relay.timer")); err != nil {
+				slog.Error("failed to switch relay back after timer store failed", "relay", id, "err", err)
+			}
+		}
+		httpError(w, r, http.StatusInternalServerError, "failed to store timer")
+		return
+	}
+	a.timerChanged(id, &t)
+	writeJSON(w, http.StatusCreated, timerResponse(t))
+}
+
+func (a *API) listRelayTimersHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := db.ListActiveRelayTimers(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list timers")
+		return
+	}
+	out := make([]RelayTimerResponse, 0, len(list))
+	for _, t := range list {
+		out = append(out, timerResponse(t))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// cancelRelayTimerHandler stops an active timer and leaves its relay as it
+// is. It needs relay:write for the timer's relay.
+func (a *API) cancelRelayTimerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid timer id")
+		return
+	}
+	t, err := db.GetRelayTimer(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown timer")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load timer")
+		return
+	}
+	relay := strconv.Itoa(t.Relay)
+	if !allowedScopes(w, r, []commandCheck{relayCheck(relay)}) {
+		return
+	}
+	ok, err := db.FinishRelayTimer(r.Context(), id, "cancelled", "")
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to cancel timer")
+		return
+	}
+	if !ok {
+		httpError(w, r, http.StatusNotFound, "timer already ended")
+		return
+	}
+	a.audit(r, "relay.timer_cancel", "relay:"+relay, map[string]int64{"timer": id}, nil)
+	a.timerChanged(t.Relay, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
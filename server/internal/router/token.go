@@ -0,0 +1,202 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type CreateTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type TokenResponse struct {
+	db.APIToken
+	Scopes []string `json:"scopes"`
+	// Token is the secret; it's only returned when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func tokenResponse(t *db.APIToken) TokenResponse {
+	return TokenResponse{APIToken: *t, Scopes: t.ScopeList()}
+}
+
+// tokenAuth authenticates a request carrying "Authorization: Bearer <token>".
+func (a *API) tokenAuth(w http.ResponseWriter, r *http.Request, secret string, next http.Handler) {
+	t, err := db.GetAPITokenByHash(r.Context(), auth.HashToken(secret))
+	if errors.Is(err, db.ErrNotFound) {
+		slog.Warn("invalid api token", "ip", clientIP(r), "path", r.URL.Path, "req_id", middleware.GetReqID(r.Context()))
+		httpError(w, r, http.StatusUnauthorized, "invalid token")
+		return
+	} else if err != nil {
+		slog.Error("token lookup failed", "err", err, "req_id", middleware.GetReqID(r.Context()))
+		httpError(w, r, http.StatusInternalServerError, "token lookup failed")
+		return
+	}
+	if err := db.TouchAPIToken(r.Context(), t.ID, time.Now()); err != nil {
+		slog.Warn("failed to record token use", "token_id", t.ID, "err", err)
+	}
+
+	ctx := auth.WithToken(r.Context(), t)
+	if t.UserID != nil {
+		u, err := db.GetUser(r.Context(), *t.UserID)
+		if err != nil {
+			httpError(w, r, http.StatusUnauthorized, "token owner not found")
+			return
+		}
+		ctx = auth.WithUser(ctx, u)
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requireScope limits a route to requests allowed scope: by the token they
+// carry and by the permissions of the user behind it. "{id}" in scope is
+// replaced with the route's id parameter.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			want := strings.ReplaceAll(scope, "{id}", chi.URLParam(r, "id"))
+			ok, err := auth.Allowed(r.Context(), want)
+			if err != nil {
+				slog.Error("permission lookup failed", "err", err, "req_id", middleware.GetReqID(r.Context()))
+				httpError(w, r, http.StatusInternalServerError, "permission lookup failed")
+				return
+			}
+			if !ok {
+				slog.Warn("permission denied", "actor", actor(r), "scope", want, "path", r.URL.Path, "req_id", middleware.GetReqID(r.Context()))
+				httpError(w, r, http.StatusForbidden, "not permitted: "+want)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// actor names who's behind a request for logs.
+func actor(r *http.Request) string {
+	name := ""
+	if u := auth.UserFrom(r.Context()); u != nil {
+		name = u.Username
+	}
+	if t := auth.TokenFrom(r.Context()); t != nil {
+		if name != "" {
+			name += "/"
+		}
+		name += "token:" + strconv.FormatInt(t.ID, 10)
+	}
+	return name
+}
+
+// sessionOnly keeps API tokens away from routes that manage credentials.
+func sessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.TokenFrom(r.Context()) != nil {
+			httpError(w, r, http.StatusForbidden, "not available to api tokens")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) listTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := db.ListAPITokens(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list tokens")
+		return
+	}
+	u := auth.UserFrom(r.Context())
+	out := make([]TokenResponse, 0, len(tokens))
+	for i := range tokens {
+		if canManageToken(u, &tokens[i]) {
+			out = append(out, tokenResponse(&tokens[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *API) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		httpError(w, r, http.StatusBadRequest, "name is required")
+		return
+	}
+	scopes, err := auth.ParseScopes(strings.Join(req.Scopes, " "))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// a token can't do more than the user creating it
+	for _, sc := range scopes {
+		ok, err := auth.Allowed(r.Context(), sc)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, "permission lookup failed")
+			return
+		}
+		if !ok {
+			httpError(w, r, http.StatusForbidden, "not permitted: "+sc)
+			return
+		}
+	}
+
+	var userID *int64
+	if u := auth.UserFrom(r.Context()); u != nil {
+		userID = &u.ID
+	}
+	secret, hash := auth.NewToken()
+	t, err := db.CreateAPIToken(r.Context(), req.Name, hash, scopes, userID)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+	slog.Info("api token created", "token_id", t.ID, "name", t.Name, "scopes", t.Scopes)
+	resp := tokenResponse(t)
+	resp.Token = secret
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (a *API) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid token id")
+		return
+	}
+	t, err := db.GetAPIToken(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !canManageToken(auth.UserFrom(r.Context()), t)) {
+		httpError(w, r, http.StatusNotFound, "unknown token")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load token")
+		return
+	}
+	if err := db.DeleteAPIToken(r.Context(), id); err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+	slog.Info("api token revoked", "token_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canManageToken: admins see every token, members only their own.
+func canManageToken(u *db.User, t *db.APIToken) bool {
+	if u == nil || u.Role == auth.RoleAdmin {
+		return true
+	}
+	return t.UserID != nil && *t.UserID == u.ID
+}
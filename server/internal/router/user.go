@@ -0,0 +1,112 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type UserResponse struct {
+	db.User
+	Permissions []string `json:"permissions"`
+}
+
+type SetPermissionsRequest struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// adminOnly limits user management to admins logged in with a session.
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			httpError(w, r, http.StatusForbidden, "admins only")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether an admin is signed in, not using a token.
+func isAdmin(r *http.Request) bool {
+	u := auth.UserFrom(r.Context())
+	return auth.TokenFrom(r.Context()) == nil && u != nil && u.Role == auth.RoleAdmin
+}
+
+func (a *API) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := db.ListUsers(r.Context())
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	out := make([]UserResponse, 0, len(users))
+	for _, u := range users {
+		perms, err := db.ListUserPermissions(r.Context(), u.ID)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, "failed to load permissions")
+			return
+		}
+		out = append(out, UserResponse{User: u, Permissions: perms})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *API) setUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	u, err := db.GetUser(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		httpError(w, r, http.StatusNotFound, "unknown user")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+
+	req := SetPermissionsRequest{Role: u.Role}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if err := auth.ValidRole(req.Role); err != nil {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	var perms []string
+	if len(req.Permissions) > 0 {
+		if perms, err = auth.ParseScopes(strings.Join(req.Permissions, " ")); err != nil {
+			httpError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if me := auth.UserFrom(r.Context()); me.ID == u.ID && req.Role != auth.RoleAdmin {
+		httpError(w, r, http.StatusBadRequest, "can't demote yourself")
+		return
+	}
+
+	if err := db.UpdateUserRole(r.Context(), id, req.Role); err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to update role")
+		return
+	}
+	if err := db.SetUserPermissions(r.Context(), id, perms); err != nil {
+		httpError(w, r, http.StatusInternalServerError, "failed to update permissions")
+		return
+	}
+	slog.Info("user permissions updated", "username", u.Username, "role", req.Role, "permissions", perms)
+	u.Role = req.Role
+	if perms == nil {
+		perms = []string{}
+	}
+	writeJSON(w, http.StatusOK, UserResponse{User: *u, Permissions: perms})
+}
@@ -0,0 +1,251 @@
+package router
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"relaypanel/internal/adb"
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// openAPISpec documents the /api/v1 surface; keep it in step with v1Routes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// tvTimeout bounds one adb key press.
+const tvTimeout = 10 * time.Second
+
+// Relay is a relay as returned by /api/v1.
+type Relay struct {
+	ID       int    `json:"id"`
+	Label    string `json:"label"`
+	State    bool   `json:"state"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+type UpdateRelayRequest struct {
+	Label *string `json:"label"`
+}
+
+// ActionResult is the body of every /api/v1 command that doesn't return a resource.
+type ActionResult struct {
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	OK     bool   `json:"ok"`
+}
+
+// v1Routes registers the versioned API. The older routes in Router stay as
+// aliases for existing clients.
+func (a *API) v1Routes(r chi.Router) {
+	scoped := func(scope string) chi.Router { return r.With(requireScope(scope)) }
+
+	r.Get("/openapi.json", openAPIHandler)
+
+	scoped(auth.ScopeRelayRead).Get("/status", a.getStatusHandler)
+	scoped(auth.ScopeRelayRead).Get("/events", a.eventsHandler)
+	scoped(auth.ScopeRelayRead).Get("/ws", a.wsHandler)
+
+	scoped(auth.ScopeRelayRead).Get("/relays", a.listRelaysV1Handler)
+	// needs relay:write:<n> for every relay it changes
+	r.Post("/relays", a.setRelaysHandler)
+	scoped(auth.ScopeRelayRead).Get("/relays/timeline", a.relayTimelineHandler)
+	scoped(auth.ScopeRelayRead).Get("/relays/{id}", a.getRelayV1Handler)
+	scoped(auth.ScopeRelayRead).Get("/relays/{id}/history", a.relayHistoryHandler)
+	scoped(auth.RelayScope("{id}")).Patch("/relays/{id}", a.updateRelayV1Handler)
+	scoped(auth.RelayScope("{id}")).With(a.limiter.limit("relay:{id}")).Post("/relays/{id}/toggle", a.toggleRelayV1Handler)
+	scoped(auth.RelayScope("{id}")).With(a.limiter.limit("relay:{id}")).Post("/relays/{id}/timer", a.startRelayTimerHandler)
+	scoped(auth.ScopeRelayRead).Get("/timers", a.listRelayTimersHandler)
+	// needs relay:write:<n> for the timer's relay
+	r.Delete("/timers/{id}", a.cancelRelayTimerHandler)
+
+	scoped(auth.ScopeDoorBuzz).With(a.limiter.limit("door")).Post("/door/buzz", a.doorBuzzV1Handler)
+	scoped(auth.ScopeDoorBuzz).Get("/door/policy", a.getDoorPolicyHandler)
+	r.With(adminOnly).Put("/door/policy", a.setDoorPolicyHandler)
+	scoped(auth.ScopeDoorBuzz).Get("/door/guest-links", a.listGuestLinksHandler)
+	scoped(auth.ScopeDoorBuzz).Post("/door/guest-links", a.createGuestLinkHandler)
+	scoped(auth.ScopeDoorBuzz).Delete("/door/guest-links/{id}", a.revokeGuestLinkHandler)
+	scoped(auth.ScopeDoorBuzz).Get("/door/guest-links/{id}/uses", a.guestLinkUsesHandler)
+
+	scoped(auth.ScopeTVControl).Get("/tv/actions", a.listTVActionsV1Handler)
+	scoped(auth.ScopeTVControl).With(a.limiter.limit("tv")).Post("/tv/actions/{action}", a.tvActionV1Handler)
+
+	scoped(auth.ScopeDevicesRead).Get("/devices", a.listDevicesHandler)
+	scoped(auth.ScopeDevicesWrite).Post("/devices/{name}/reboot", a.rebootDeviceHandler)
+	scoped(auth.ScopeDevicesWrite).Put("/devices/{name}/ota-config", a.setDeviceOTAHandler)
+	scoped(auth.ScopeDevicesWrite).Post("/devices/{name}/ota-jobs", a.startOTAHandler)
+	scoped(auth.ScopeDevicesRead).Get("/devices/{name}/firmware", a.firmwareHistoryHandler)
+	scoped(auth.ScopeDevicesRead).Get("/devices/{name}/telemetry", a.telemetryHistoryHandler)
+	scoped(auth.ScopeDevicesRead).Get("/firmware", a.listFirmwareHandler)
+	scoped(auth.ScopeDevicesWrite).Post("/firmware", a.uploadFirmwareHandler)
+	scoped(auth.ScopeDevicesRead).Get("/ota-jobs", a.listOTAJobsHandler)
+	scoped(auth.ScopeDevicesRead).Get("/ota-jobs/{id}", a.getOTAJobHandler)
+
+	scoped(auth.ScopeSensorsRead).Get("/sensors", a.listSensorsHandler)
+	scoped(auth.ScopeSensorsWrite).Patch("/sensors/{id}", a.updateSensorHandler)
+	scoped(auth.ScopeSensorsRead).Get("/sensors/{id}/history", a.sensorHistoryHandler)
+
+	scoped(auth.ScopeScenesRead).Get("/scenes", a.listScenesHandler)
+	scoped(auth.ScopeScenesWrite).Post("/scenes", a.createSceneHandler)
+	scoped(auth.ScopeScenesRead).Get("/scenes/{id}", a.getSceneHandler)
+	scoped(auth.ScopeScenesWrite).Put("/scenes/{id}", a.updateSceneHandler)
+	scoped(auth.ScopeScenesWrite).Delete("/scenes/{id}", a.deleteSceneHandler)
+	// also needs the scope of every command in the scene
+	scoped(auth.ScopeScenesRead).Post("/scenes/{id}/run", a.runSceneHandler)
+	scoped(auth.ScopeScenesRead).Get("/scene-runs", a.listSceneRunsHandler)
+	scoped(auth.ScopeScenesRead).Get("/scene-runs/{id}", a.getSceneRunHandler)
+
+	scoped(auth.ScopeSchedulesRead).Get("/schedules", a.listSchedulesHandler)
+	scoped(auth.ScopeSchedulesWrite).Post("/schedules", a.createScheduleHandler)
+	scoped(auth.ScopeSchedulesRead).Get("/schedules/preview", a.previewScheduleHandler)
+	scoped(auth.ScopeSchedulesRead).Get("/schedules/sun", a.sunTimesHandler)
+	scoped(auth.ScopeSchedulesRead).Get("/schedules/{id}", a.getScheduleHandler)
+	scoped(auth.ScopeSchedulesWrite).Put("/schedules/{id}", a.updateScheduleHandler)
+	scoped(auth.ScopeSchedulesWrite).Delete("/schedules/{id}", a.deleteScheduleHandler)
+
+	scoped(auth.ScopeRulesRead).Get("/rules", a.listRulesHandler)
+	scoped(auth.ScopeRulesWrite).Post("/rules", a.createRuleHandler)
+	scoped(auth.ScopeRulesRead).Get("/rules/{id}", a.getRuleHandler)
+	scoped(auth.ScopeRulesWrite).Put("/rules/{id}", a.updateRuleHandler)
+	scoped(auth.ScopeRulesWrite).Delete("/rules/{id}", a.deleteRuleHandler)
+
+	r.With(sessionOnly).Get("/tokens", a.listTokensHandler)
+	r.With(sessionOnly).Post("/tokens", a.createTokenHandler)
+	r.With(sessionOnly).Delete("/tokens/{id}", a.revokeTokenHandler)
+	r.With(adminOnly).Get("/users", a.listUsersHandler)
+	r.With(adminOnly).Put("/users/{id}/permissions", a.setUserPermissionsHandler)
+	r.With(adminOnly).Get("/confirmations", a.listConfirmationsHandler)
+	r.With(adminOnly).Get("/audit", a.listAuditHandler)
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPISpec)
+}
+
+func (a *API) relays(r *http.Request) []Relay {
+	st := a.status(r).RelayStates
+	out := make([]Relay, len(st))
+	for i, s := range st {
+		out[i] = Relay{ID: i + 1, Label: s.Label, State: s.State, ReadOnly: s.ReadOnly}
+	}
+	return out
+}
+
+// relayID parses the {id} of /api/v1/relays/{id}.
+func relayID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 || id > 8 {
+		httpError(w, r, http.StatusBadRequest, "invalid relay id")
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *API) listRelaysV1Handler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.relays(r))
+}
+
+func (a *API) getRelayV1Handler(w http.ResponseWriter, r *http.Request) {
+	id, ok := relayID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.relays(r)[id-1])
+}
+
+func (a *API) updateRelayV1Handler(w http.ResponseWriter, r *http.Request) {
+	id, ok := relayID(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateRelayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Label != nil {
+		label := strings.TrimSpace(*req.Label)
+		err := db.UpdateRelayLabel(r.Context(), int64(id), label)
+		a.audit(r, "relay.label", "relay:"+strconv.Itoa(id), map[string]string{"label": label}, err)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, "failed to update label in database")
+			return
+		}
+		a.Devices.UpdateLabel(id-1, label)
+		slog.Info("relay label updated", "relay_index", id, "label", label)
+	}
+	writeJSON(w, http.StatusOK, a.relays(r)[id-1])
+}
+
+func (a *API) toggleRelayV1Handler(w http.ResponseWriter, r *http.Request) {
+	id, ok := relayID(w, r)
+	if !ok {
+		return
+	}
+	target := strconv.Itoa(id)
+	if !a.confirm(w, r, auth.RelayAction(target)) {
+		return
+	}
+	err := a.Devices.ToggleRelayAs(target, origin(r, "relay.toggle"))
+	a.audit(r, "relay.toggle", "relay:"+target, nil, err)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ActionResult{Action: "relay.toggle", Target: target, OK: true})
+}
+
+func (a *API) doorBuzzV1Handler(w http.ResponseWriter, r *http.Request) {
+	if !a.confirm(w, r, auth.ActionDoor) {
+		return
+	}
+	err := a.buzzDoor(r.Context())
+	a.audit(r, "door.buzz", "door", nil, err)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ActionResult{Action: "door.buzz", OK: true})
+}
+
+func (a *API) listTVActionsV1Handler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(adb.Actions))
+	for name := range adb.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (a *API) tvActionV1Handler(w http.ResponseWriter, r *http.Request) {
+	action := chi.URLParam(r, "action")
+	code, ok := adb.Actions[action]
+	if !ok {
+		httpError(w, r, http.StatusNotFound, "unknown tv action")
+		return
+	}
+	if a.ADB == nil {
+		httpError(w, r, http.StatusServiceUnavailable, "adb client not configured")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), tvTimeout)
+	defer cancel()
+	err := a.ADB.SendKey(ctx, code)
+	a.audit(r, "tv."+action, "tv", nil, err)
+	if err != nil {
+		writeErr(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ActionResult{Action: "tv." + action, OK: true})
+}
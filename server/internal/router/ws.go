@@ -0,0 +1,224 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/adb"
+	"relaypanel/internal/auth"
+	"relaypanel/internal/events"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// WSRequest is a command sent over /ws:
+//
+//	{"id": "1", "type": "relay.toggle", "relay": 3}
+//	{"id": "2", "type": "door.buzz", "code": "1234"}
+//	{"id": "3", "type": "tv", "action": "dpad_up"}
+//	{"id": "4", "type": "status"}
+//
+// Code is the PIN/TOTP for guarded actions, as X-Confirm-Code is for REST.
+type WSRequest struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Relay  int    `json:"relay,omitempty"`
+	Action string `json:"action,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// WSMessage is sent by the server: an "ack" for every request, with the
+// HTTP status the REST route would have answered, and "event" for every
+// live state change (the same events as /events). Failed acks carry the same
+// error code as the REST error envelope.
+type WSMessage struct {
+	Type   string        `json:"type"`
+	ID     string        `json:"id,omitempty"`
+	OK     bool          `json:"ok,omitempty"`
+	Status int           `json:"status,omitempty"`
+	Code   string        `json:"code,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Data   any           `json:"data,omitempty"`
+	Event  *events.Event `json:"event,omitempty"`
+}
+
+// wsHandler upgrades to a WebSocket carrying state and commands. The
+// connection is authenticated like any other request, and every command is
+// checked against the same scopes and confirmation PINs as its REST route.
+func (a *API) wsHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns: []string{requestHost(r)},
+	})
+	if err != nil {
+		slog.Warn("websocket accept failed", "err", err, "ip", clientIP(r))
+		return
+	}
+	defer c.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	send := func(m WSMessage) error {
+		wctx, wcancel := context.WithTimeout(ctx, wsWriteTimeout)
+		defer wcancel()
+		return wsjson.Write(wctx, c, m)
+	}
+
+	if err := send(WSMessage{Type: "status", Data: a.status(r)}); err != nil {
+		return
+	}
+
+	if a.Events != nil {
+		_, _, ch, unsubscribe := a.Events.Subscribe(0)
+		// cancel first, so the channel closing below isn't taken for a drop
+		defer func() {
+			cancel()
+			unsubscribe()
+		}()
+		go func() {
+			defer cancel()
+			for e := range ch {
+				if err := send(WSMessage{Type: "event", Event: &e}); err != nil {
+					return
+				}
+			}
+			if ctx.Err() == nil {
+				// the hub dropped us for falling behind
+				c.Close(websocket.StatusTryAgainLater, "too slow")
+			}
+		}()
+	}
+
+	go func() {
+		t := time.NewTicker(wsPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := c.Ping(ctx); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	who := actor(r)
+	slog.Info("websocket connected", "actor", who, "ip", clientIP(r))
+	defer slog.Info("websocket closed", "actor", who, "ip", clientIP(r))
+
+	// Commands run in order; a d-pad press shouldn't overtake the one before.
+	for {
+		var req WSRequest
+		if err := wsjson.Read(ctx, c, &req); err != nil {
+			var ce websocket.CloseError
+			if !errors.As(err, &ce) && ctx.Err() == nil {
+				slog.Warn("websocket read failed", "actor", who, "err", err)
+			}
+			return
+		}
+		if err := send(a.wsCommand(r, req)); err != nil {
+			return
+		}
+	}
+}
+
+func (a *API) wsCommand(r *http.Request, req WSRequest) WSMessage {
+	ack := WSMessage{Type: "ack", ID: req.ID}
+	fail := func(status int, msg string) WSMessage {
+		ack.Status, ack.Code, ack.Error = status, statusCodes[status], msg
+		return ack
+	}
+	failErr := func(err error) WSMessage {
+		ack.Status, ack.Code = errorStatus(err)
+		ack.Error = err.Error()
+		if ack.Status == http.StatusInternalServerError {
+			slog.Error("websocket command failed", "type", req.Type, "err", err)
+			ack.Error = "internal error"
+		}
+		return ack
+	}
+
+	var scope, action string
+	switch req.Type {
+	case "status":
+		ack.OK, ack.Status, ack.Data = true, http.StatusOK, a.status(r)
+		return ack
+	case "relay.toggle":
+		if req.Relay < 1 || req.Relay > 8 {
+			return fail(http.StatusBadRequest, "invalid relay id")
+		}
+		id := strconv.Itoa(req.Relay)
+		scope, action = auth.RelayScope(id), auth.RelayAction(id)
+	case "door.buzz":
+		scope, action = auth.ScopeDoorBuzz, auth.ActionDoor
+	case "tv":
+		if _, ok := adb.Actions[req.Action]; !ok {
+			return fail(http.StatusBadRequest, "unknown tv action")
+		}
+		scope = auth.ScopeTVControl
+	default:
+		return fail(http.StatusBadRequest, "unknown message type")
+	}
+
+	ok, err := auth.Allowed(r.Context(), scope)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "permission lookup failed")
+	}
+	if !ok {
+		slog.Warn("permission denied", "actor", actor(r), "scope", scope, "via", "ws")
+		return fail(http.StatusForbidden, "not permitted: "+scope)
+	}
+	limited := func(resource string, wait time.Duration) WSMessage {
+		ack.Status, ack.Code = http.StatusTooManyRequests, "rate_limited"
+		ack.Error = "too many requests for " + resource + "; retry in " + wait.Round(time.Second).String()
+		return ack
+	}
+	if ok, wait := a.limiter.allow(callerKey(r), a.limiter.limits.Client); !ok {
+		return limited("client", wait)
+	}
+	if res := scopeResource(scope); res != "" {
+		if ok, wait := a.limiter.allowResource(r, res); !ok {
+			return limited(res, wait)
+		}
+	}
+	if action != "" && auth.TokenFrom(r.Context()) == nil {
+		if err := auth.Confirm(r.Context(), action, actor(r), clientIP(r), req.Code); err != nil {
+			return failErr(err)
+		}
+	}
+
+	switch req.Type {
+	case "relay.toggle":
+		err = a.Devices.ToggleRelayAs(strconv.Itoa(req.Relay), origin(r, "relay.toggle"))
+		a.audit(r, "relay.toggle", "relay:"+strconv.Itoa(req.Relay), nil, err)
+	case "door.buzz":
+		err = a.buzzDoor(r.Context())
+		a.audit(r, "door.buzz", "door", nil, err)
+	case "tv":
+		if a.ADB == nil {
+			return fail(http.StatusServiceUnavailable, "adb client not configured")
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), tvTimeout)
+		defer cancel()
+		err = a.ADB.SendKey(ctx, adb.Actions[req.Action])
+		a.audit(r, "tv."+req.Action, "tv", nil, err)
+	}
+	if err != nil {
+		return failErr(err)
+	}
+	ack.OK, ack.Status = true, http.StatusOK
+	return ack
+}
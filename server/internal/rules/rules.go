@@ -0,0 +1,495 @@
+// Package rules runs event-triggered automations: when a device or TV event
+// matches a rule's trigger and all its conditions hold, the rule's actions
+// run in order. Every evaluation is traced in the log under one trace ID.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"relaypanel/internal/adb"
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+	"relaypanel/internal/events"
+)
+
+// Trigger events.
+const (
+	EventRing   = "ring"   // the doorbell rang; Device optionally narrows it
+	EventDevice = "device" // a board connected or disconnected; Device and State optional
+	EventRelay  = "relay"  // Relay switched; State ("on"/"off") optional
+	EventTV     = "tv"     // the TV changed; State optional (on, off, playing, paused, stopped, unreachable)
+)
+
+// Condition types.
+const (
+	CondRelay  = "relay"  // Relay is State ("on"/"off")
+	CondTime   = "time"   // the time of day is within From-To ("HH:MM") in TimeZone
+	CondDevice = "device" // Device is State ("connected"/"disconnected"), with RSSI >= MinRSSI if set
+	CondTV     = "tv"     // the TV is State (on, off, playing, paused, stopped, unreachable)
+)
+
+const (
+	MaxConditions = 16
+	MaxActions    = 16
+
+	// minGap is the least time between two firings of a rule, whatever its
+	// cooldown, so a rule whose action re-triggers it can't spin.
+	minGap = time.Second
+
+	// maxReplayAge is the oldest an event replayed after falling behind may
+	// be and still fire rules.
+	maxReplayAge = 10 * time.Second
+)
+
+var (
+	devices      = []string{"relays", "buzzer"}
+	deviceStates = []string{"connected", "disconnected"}
+	relayStates  = []string{"on", "off"}
+	tvStates     = []string{adb.PowerOn, adb.PowerOff, adb.PlaybackPlaying, adb.PlaybackPaused, adb.PlaybackStopped, "unreachable"}
+)
+
+// Validate checks a rule's name, trigger, conditions and the number of
+// actions before it's stored. The actions themselves are the caller's to
+// check.
+func Validate(ru *db.Rule) error {
+	if ru.Name == "" {
+		return errors.New("name is required")
+	}
+	if ru.CooldownS < 0 {
+		return errors.New("invalid cooldown_s")
+	}
+	if err := validTrigger(ru.Trigger); err != nil {
+		return fmt.Errorf("trigger: %w", err)
+	}
+	if len(ru.Conditions) > MaxConditions {
+		return fmt.Errorf("a rule takes at most %d conditions", MaxConditions)
+	}
+	for i, c := range ru.Conditions {
+		if err := validCondition(c); err != nil {
+			return fmt.Errorf("condition %d: %w", i+1, err)
+		}
+	}
+	if len(ru.Actions) == 0 || len(ru.Actions) > MaxActions {
+		return fmt.Errorf("a rule needs 1 to %d actions", MaxActions)
+	}
+	return nil
+}
+
+func validTrigger(t db.RuleTrigger) error {
+	switch t.Event {
+	case EventRing:
+		if t.State != "" || t.Relay != 0 {
+			return errors.New("a ring trigger takes only a device")
+		}
+		return oneOf("device", t.Device, devices, true)
+	case EventDevice:
+		if t.Relay != 0 {
+			return errors.New("a device trigger takes no relay")
+		}
+		if err := oneOf("device", t.Device, devices, true); err != nil {
+			return err
+		}
+		return oneOf("state", t.State, deviceStates, true)
+	case EventRelay:
+		if t.Relay < 1 || t.Relay > 8 || t.Device != "" {
+			return errors.New("a relay trigger needs a relay 1-8")
+		}
+		return oneOf("state", t.State, relayStates, true)
+	case EventTV:
+		if t.Relay != 0 || t.Device != "" {
+			return errors.New("a tv trigger takes only a state")
+		}
+		return oneOf("state", t.State, tvStates, true)
+	}
+	return fmt.Errorf("unknown event %q (ring, device, relay or tv)", t.Event)
+}
+
+func validCondition(c db.RuleCondition) error {
+	switch c.Type {
+	case CondRelay:
+		if c.Relay < 1 || c.Relay > 8 {
+			return errors.New("a relay condition needs a relay 1-8")
+		}
+		return oneOf("state", c.State, relayStates, false)
+	case CondTime:
+		from, err := parseClock(c.From)
+		if err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		to, err := parseClock(c.To)
+		if err != nil {
+			return fmt.Errorf("to: %w", err)
+		}
+		if from == to {
+			return errors.New("from and to must differ")
+		}
+		if c.TimeZone != "" {
+			if _, err := time.LoadLocation(c.TimeZone); err != nil {
+				return fmt.Errorf("unknown time zone %q", c.TimeZone)
+			}
+		}
+		return nil
+	case CondDevice:
+		if err := oneOf("device", c.Device, devices, false); err != nil {
+			return err
+		}
+		if c.MinRSSI > 0 {
+			return errors.New("min_rssi is in dBm and must be negative")
+		}
+		return oneOf("state", c.State, deviceStates, false)
+	case CondTV:
+		return oneOf("state", c.State, tvStates, false)
+	}
+	return fmt.Errorf("unknown type %q (relay, time, device or tv)", c.Type)
+}
+
+func oneOf(field, v string, allowed []string, optional bool) error {
+	if v == "" && optional {
+		return nil
+	}
+	for _, a := range allowed {
+		if v == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %s", field, strings.Join(allowed, ", "))
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("want HH:MM, got %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Engine evaluates rules against the event hub. Run carries out an action;
+// it should go through the same command path as the API.
+type Engine struct {
+	Run     func(ctx context.Context, o device.Origin, t db.Target) error
+	Devices *device.Manager
+
+	wake chan struct{}
+
+	mu        sync.Mutex
+	rules     []db.Rule
+	tv        *adb.TVState
+	relays    []bool
+	lastFired map[int64]time.Time
+	trace     uint64
+}
+
+func New(run func(ctx context.Context, o device.Origin, t db.Target) error, devices *device.Manager) *Engine {
+	return &Engine{Run: run, Devices: devices, wake: make(chan struct{}, 1), lastFired: map[int64]time.Time{}}
+}
+
+// Reload makes the engine pick up added, changed or deleted rules.
+func (e *Engine) Reload() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start evaluates rules against hub's events until ctx ends.
+func (e *Engine) Start(ctx context.Context, hub *events.Hub) {
+	e.load(ctx)
+	e.reset()
+	go func() {
+		var lastID uint64
+		for {
+			missed, complete, ch, cancel := hub.Subscribe(lastID)
+			if complete {
+				for _, ev := range missed {
+					e.replay(ctx, ev)
+				}
+			} else {
+				// Some changes are gone, so what's remembered may be off;
+				// the manager's current state takes them all in.
+				slog.Warn("rules missed events; reloading relay and tv state", "last_event", lastID)
+				e.reset()
+			}
+			if n := len(missed); n > 0 {
+				lastID = missed[n-1].ID
+			}
+			lastID = e.listen(ctx, ch, lastID)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("rules fell behind the event stream; resubscribing", "last_event", lastID)
+		}
+	}()
+}
+
+// reset takes the relay states from the manager, which only publishes ones
+// that differ, and forgets the TV state until the next poll.
+func (e *Engine) reset() {
+	states := e.Devices.RelayStates()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.relays = make([]bool, len(states))
+	for i, st := range states {
+		e.relays[i] = st.State
+	}
+	e.tv = nil
+}
+
+// replay handles an event that happened while resubscribing. Past
+// maxReplayAge it only updates the state it carries: a ring or a switch from
+// a while ago shouldn't set anything off now.
+func (e *Engine) replay(ctx context.Context, ev events.Event) {
+	if age := time.Since(ev.At); age > maxReplayAge {
+		slog.Debug("not firing rules for an old event", "event", ev.ID, "type", ev.Type, "age", age.Round(time.Second))
+		e.changes(ev)
+		return
+	}
+	e.handle(ctx, ev)
+}
+
+// listen handles events until ctx ends or the hub drops ch, and returns
+// the last event handled.
+func (e *Engine) listen(ctx context.Context, ch <-chan events.Event, lastID uint64) uint64 {
+	for {
+		select {
+		case <-ctx.Done():
+			return lastID
+		case <-e.wake:
+			e.load(ctx)
+		case ev, ok := <-ch:
+			if !ok {
+				return lastID
+			}
+			e.handle(ctx, ev)
+			lastID = ev.ID
+		}
+	}
+}
+
+func (e *Engine) load(ctx context.Context) {
+	list, err := db.ListRules(ctx)
+	if err != nil {
+		slog.Error("failed to load rules", "err", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = list
+}
+
+// change is an event as rules see it: a relay switch, or a TV change, is
+// split out of the hub's whole-state events.
+type change struct {
+	event  string
+	device string
+	relay  int
+	states []string // what the thing is now, for matching a trigger's State
+	detail string
+}
+
+func (e *Engine) handle(ctx context.Context, ev events.Event) {
+	for _, c := range e.changes(ev) {
+		e.mu.Lock()
+		rules := e.rules
+		e.mu.Unlock()
+		for i := range rules {
+			if ru := &rules[i]; ru.Enabled && matches(ru.Trigger, c) {
+				e.evaluate(ctx, ru, ev, c)
+			}
+		}
+	}
+}
+
+// changes turns a hub event into the changes rules can trigger on, keeping
+// the last relay and TV state to tell what changed.
+func (e *Engine) changes(ev events.Event) []change {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch d := ev.Data.(type) {
+	case events.Ring:
+		return []change{{event: EventRing, device: d.Device, detail: "ring from " + d.Device}}
+	case events.DeviceChange:
+		return []change{{event: EventDevice, device: d.Name, states: []string{d.State}, detail: d.Name + " " + d.State}}
+	case []device.RelayState:
+		prev := e.relays
+		e.relays = make([]bool, len(d))
+		var out []change
+		for i, st := range d {
+			e.relays[i] = st.State
+			if i >= len(prev) || prev[i] == st.State {
+				continue
+			}
+			s := onOff(st.State)
+			out = append(out, change{event: EventRelay, relay: i + 1, states: []string{s}, detail: fmt.Sprintf("relay %d %s", i+1, s)})
+		}
+		return out
+	case adb.TVState:
+		prev := e.tv
+		e.tv = &d
+		if prev == nil {
+			return nil // the first poll sets the baseline
+		}
+		var states []string
+		switch {
+		case !d.Reachable && prev.Reachable:
+			states = append(states, "unreachable")
+		case d.Reachable:
+			if d.Power != prev.Power || !prev.Reachable {
+				states = append(states, d.Power)
+			}
+			if d.Playback != prev.Playback || !prev.Reachable {
+				states = append(states, d.Playback)
+			}
+		}
+		if len(states) == 0 {
+			return nil
+		}
+		return []change{{event: EventTV, states: states, detail: "tv " + strings.Join(states, ", ")}}
+	}
+	return nil
+}
+
+func matches(t db.RuleTrigger, c change) bool {
+	if t.Event != c.event || (t.Device != "" && t.Device != c.device) || (t.Relay != 0 && t.Relay != c.relay) {
+		return false
+	}
+	if t.State == "" {
+		return true
+	}
+	for _, s := range c.states {
+		if s == t.State {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate checks a triggered rule's cooldown and conditions and, if they
+// pass, runs its actions in the background.
+func (e *Engine) evaluate(ctx context.Context, ru *db.Rule, ev events.Event, c change) {
+	now := time.Now()
+	e.mu.Lock()
+	e.trace++
+	trace := fmt.Sprintf("%d-%d", ev.ID, e.trace)
+	log := slog.With("rule", ru.Name, "rule_id", ru.ID, "trace", trace)
+	cooldown := max(time.Duration(ru.CooldownS)*time.Second, minGap)
+	if last, ok := e.lastFired[ru.ID]; ok && now.Sub(last) < cooldown {
+		e.mu.Unlock()
+		log.Info("rule skipped: cooling down", "event", c.detail, "remaining", (cooldown - now.Sub(last)).Round(time.Second))
+		return
+	}
+	e.mu.Unlock()
+
+	log.Info("rule triggered", "event", c.detail, "event_id", ev.ID)
+	for i, cond := range ru.Conditions {
+		ok, detail := e.check(cond, now)
+		log.Info("rule condition", "condition", i+1, "type", cond.Type, "ok", ok, "detail", detail)
+		if !ok {
+			log.Info("rule not run: condition failed", "condition", i+1)
+			return
+		}
+	}
+
+	e.mu.Lock()
+	e.lastFired[ru.ID] = now
+	e.mu.Unlock()
+
+	actions := ru.Actions
+	go func() {
+		o := device.Origin{Command: "rule:" + ru.Name, Actor: "rule:" + strconv.FormatInt(ru.ID, 10)}
+		var errs []error
+		for i, t := range actions {
+			err := e.Run(ctx, o, t)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("action %d (%s): %w", i+1, t.Type, err))
+				log.Warn("rule action failed", "action", i+1, "type", t.Type, "err", err)
+				continue
+			}
+			log.Info("rule action ran", "action", i+1, "type", t.Type)
+		}
+		result, msg := "ok", ""
+		if err := errors.Join(errs...); err != nil {
+			result, msg = "failed", err.Error()
+		}
+		log.Info("rule finished", "result", result, "took", time.Since(now).Round(time.Millisecond))
+		if err := db.RecordRuleRun(context.WithoutCancel(ctx), ru.ID, now, result, msg); err != nil {
+			log.Error("failed to record rule run", "err", err)
+		}
+	}()
+}
+
+// check evaluates one condition at now and describes what it saw.
+func (e *Engine) check(c db.RuleCondition, now time.Time) (bool, string) {
+	switch c.Type {
+	case CondRelay:
+		states := e.Devices.RelayStates()
+		if c.Relay > len(states) {
+			return false, "relay state unknown"
+		}
+		s := onOff(states[c.Relay-1].State)
+		return s == c.State, fmt.Sprintf("relay %d is %s", c.Relay, s)
+	case CondTime:
+		loc := time.Local
+		if c.TimeZone != "" {
+			if l, err := time.LoadLocation(c.TimeZone); err == nil {
+				loc = l
+			}
+		}
+		from, _ := parseClock(c.From)
+		to, _ := parseClock(c.To)
+		t := now.In(loc)
+		m := t.Hour()*60 + t.Minute()
+		in := from <= m && m < to
+		if from > to { // wraps midnight
+			in = m >= from || m < to
+		}
+		where := "outside"
+		if in {
+			where = "within"
+		}
+		return in, fmt.Sprintf("%s is %s %s-%s", t.Format("15:04 MST"), where, c.From, c.To)
+	case CondDevice:
+		state := "disconnected"
+		if e.Devices.GetDevice(c.Device) != nil {
+			state = "connected"
+		}
+		if state != c.State {
+			return false, c.Device + " is " + state
+		}
+		if c.MinRSSI == 0 {
+			return true, c.Device + " is " + state
+		}
+		tm := e.Devices.Telemetry(c.Device)
+		if tm == nil {
+			return false, c.Device + " has reported no telemetry"
+		}
+		return tm.RSSI >= c.MinRSSI, fmt.Sprintf("%s is %s, rssi %d dBm", c.Device, state, tm.RSSI)
+	case CondTV:
+		e.mu.Lock()
+		tv := e.tv
+		e.mu.Unlock()
+		if tv == nil {
+			return false, "tv state not known yet"
+		}
+		if !tv.Reachable {
+			return c.State == "unreachable", "tv is unreachable"
+		}
+		return c.State == tv.Power || c.State == tv.Playback, fmt.Sprintf("tv is %s, %s", tv.Power, tv.Playback)
+	}
+	return false, "unknown condition type " + c.Type
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
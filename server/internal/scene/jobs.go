@@ -0,0 +1,125 @@
+package scene
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+)
+
+// Job states.
+const (
+	StateRunning = "running"
+	StateDone    = "done"
+)
+
+// keepJobs is how many finished runs Jobs remembers.
+const keepJobs = 100
+
+// Job is the live progress of a scene started with Start. Steps holds
+// the steps finished so far, in order, and OK is whether none of them failed.
+type Job struct {
+	ID      int64        `json:"id"`
+	SceneID int64        `json:"scene_id"`
+	Scene   string       `json:"scene"`
+	State   string       `json:"state"`
+	OK      bool         `json:"ok"`
+	Total   int          `json:"total"`
+	Steps   []StepResult `json:"steps"`
+
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (j *Job) clone() *Job {
+	cp := *j
+	cp.Steps = make([]StepResult, len(j.Steps))
+	copy(cp.Steps, j.Steps)
+	return &cp
+}
+
+// Start runs sc in the background, like Run, and returns a snapshot of the
+// new run at once. The run isn't tied to any request; done, if not nil, is
+// called with the finished run.
+func (rn *Runner) Start(sc *db.Scene, o device.Origin, done func(*Job)) *Job {
+	rn.mu.Lock()
+	if rn.jobs == nil {
+		rn.jobs = make(map[int64]*Job)
+	}
+	rn.lastID++
+	run := &Job{
+		ID:        rn.lastID,
+		SceneID:   sc.ID,
+		Scene:     sc.Name,
+		State:     StateRunning,
+		OK:        true,
+		Total:     len(sc.Steps),
+		Steps:     []StepResult{},
+		StartedAt: time.Now().UTC(),
+	}
+	rn.jobs[run.ID] = run
+	rn.pruneLocked()
+	snapshot := run.clone()
+	rn.mu.Unlock()
+
+	go func() {
+		rn.run(context.Background(), sc, o, func(res StepResult) {
+			rn.mu.Lock()
+			defer rn.mu.Unlock()
+			run.Steps = append(run.Steps, res)
+			run.OK = run.OK && res.OK
+		})
+		rn.mu.Lock()
+		now := time.Now().UTC()
+		run.State, run.FinishedAt = StateDone, &now
+		finished := run.clone()
+		rn.mu.Unlock()
+		if done != nil {
+			done(finished)
+		}
+	}()
+	return snapshot
+}
+
+// pruneLocked forgets the oldest finished runs beyond keepJobs.
+func (rn *Runner) pruneLocked() {
+	var finished []int64
+	for id, run := range rn.jobs {
+		if run.State == StateDone {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= keepJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i] < finished[k] })
+	for _, id := range finished[:len(finished)-keepJobs] {
+		delete(rn.jobs, id)
+	}
+}
+
+// Job returns a snapshot of a run, or nil if it isn't known.
+func (rn *Runner) Job(id int64) *Job {
+	rn.mu.RLock()
+	defer rn.mu.RUnlock()
+	run, ok := rn.jobs[id]
+	if !ok {
+		return nil
+	}
+	return run.clone()
+}
+
+// Jobs returns snapshots of the running and recently finished runs,
+// newest first.
+func (rn *Runner) Jobs() []Job {
+	rn.mu.RLock()
+	defer rn.mu.RUnlock()
+	runs := make([]Job, 0, len(rn.jobs))
+	for _, run := range rn.jobs {
+		runs = append(runs, *run.clone())
+	}
+	sort.Slice(runs, func(i, k int) bool { return runs[i].ID > runs[k].ID })
+	return runs
+}
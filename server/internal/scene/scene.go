@@ -0,0 +1,198 @@
+// Package scene runs stored scenes: ordered relay, TV and door steps with
+// optional delays between them.
+package scene
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"relaypanel/internal/adb"
+	"relaypanel/internal/auth"
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+)
+
+// Step types.
+const (
+	StepRelays = "relays" // set States, or apply a relay Action (all_off, all_on)
+	StepTV     = "tv"     // press the adb.Actions key named by Action
+	StepDoor   = "door"   // buzz the door
+)
+
+const (
+	MaxSteps = 32
+	// MaxDelay bounds the wait before a single step.
+	MaxDelay = 10 * time.Minute
+	// tvTimeout bounds one adb key press.
+	tvTimeout = 10 * time.Second
+)
+
+// RelayActions are the named relay step actions, as (mask, which). POST
+// /relays accepts the same names.
+var RelayActions = map[string][2]byte{
+	"all_off": {0x00, 0xFF},
+	"all_on":  {0xFF, 0xFF},
+}
+
+// RelayBits turns relay states keyed by relay number ("1".."8"), or a named
+// relay action, into the desired bitmask and the relays it covers.
+func RelayBits(states map[string]bool, action string) (mask, which byte, ok bool) {
+	if action != "" {
+		if len(states) > 0 {
+			return 0, 0, false
+		}
+		a, ok := RelayActions[action]
+		return a[0], a[1], ok
+	}
+	for k, on := range states {
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 1 || n > 8 {
+			return 0, 0, false
+		}
+		which |= 1 << (n - 1)
+		if on {
+			mask |= 1 << (n - 1)
+		}
+	}
+	return mask, which, which != 0
+}
+
+// Validate checks a scene's name and steps before it's stored.
+func Validate(name string, steps []db.SceneStep) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if len(steps) == 0 || len(steps) > MaxSteps {
+		return fmt.Errorf("a scene needs 1 to %d steps", MaxSteps)
+	}
+	for i, st := range steps {
+		if st.DelayMS < 0 || time.Duration(st.DelayMS)*time.Millisecond > MaxDelay {
+			return fmt.Errorf("step %d: delay_ms must be between 0 and %d", i+1, MaxDelay.Milliseconds())
+		}
+		switch st.Type {
+		case StepRelays:
+			if _, _, ok := RelayBits(st.States, st.Action); !ok {
+				return fmt.Errorf(`step %d: want "states" keyed by relay 1-8, or an "action" (all_off, all_on)`, i+1)
+			}
+		case StepTV:
+			if _, ok := adb.Actions[st.Action]; !ok || len(st.States) > 0 {
+				return fmt.Errorf("step %d: unknown tv action %q", i+1, st.Action)
+			}
+		case StepDoor:
+			if st.Action != "" || len(st.States) > 0 {
+				return fmt.Errorf("step %d: a door step takes no action or states", i+1)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown type %q (relays, tv or door)", i+1, st.Type)
+		}
+	}
+	return nil
+}
+
+// StepResult is how one step went. Err is the raw error, for callers that map
+// it to an error code.
+type StepResult struct {
+	Step   int    `json:"step"`
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	OK     bool   `json:"ok"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Mask is the relay bitmask the board confirmed after a relays step.
+	Mask *int  `json:"mask,omitempty"`
+	Err  error `json:"-"`
+}
+
+// Failed returns the error of the first step that failed, if any.
+func Failed(results []StepResult) error {
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// Runner runs scenes against the relay board and the TV.
+type Runner struct {
+	Devices *device.Manager
+	ADB     *adb.Client
+
+	mu     sync.RWMutex
+	lastID int64
+	jobs   map[int64]*Job
+}
+
+// Run runs every step of sc in order, waiting each step's delay first. A
+// failed step doesn't stop the ones after it; ctx ending does, and the
+// remaining steps are reported as failed with ctx's error.
+func (rn *Runner) Run(ctx context.Context, sc *db.Scene, o device.Origin) []StepResult {
+	results := make([]StepResult, 0, len(sc.Steps))
+	rn.run(ctx, sc, o, func(res StepResult) { results = append(results, res) })
+	return results
+}
+
+// run is Run, handing each step's result to done as soon as it has one.
+func (rn *Runner) run(ctx context.Context, sc *db.Scene, o device.Origin, done func(StepResult)) {
+	for i, st := range sc.Steps {
+		res := StepResult{Step: i + 1, Type: st.Type, Action: st.Action}
+		if err := sleep(ctx, time.Duration(st.DelayMS)*time.Millisecond); err != nil {
+			res.Err = err
+		} else {
+			res.Err = rn.runStep(ctx, st, o, &res)
+			res.OK = res.Err == nil
+		}
+		done(res)
+	}
+}
+
+func (rn *Runner) runStep(ctx context.Context, st db.SceneStep, o device.Origin, res *StepResult) error {
+	switch st.Type {
+	case StepRelays:
+		mask, which, ok := RelayBits(st.States, st.Action)
+		if !ok {
+			return fmt.Errorf("invalid relay step")
+		}
+		got, err := rn.Devices.SetRelays(ctx, mask, which, o)
+		if err == nil {
+			m := int(got)
+			res.Mask = &m
+		}
+		return err
+	case StepTV:
+		code, ok := adb.Actions[st.Action]
+		if !ok {
+			return fmt.Errorf("unknown tv action %q", st.Action)
+		}
+		if rn.ADB == nil {
+			return adb.ErrNotInstalled
+		}
+		ctx, cancel := context.WithTimeout(ctx, tvTimeout)
+		defer cancel()
+		return rn.ADB.SendKey(ctx, code)
+	case StepDoor:
+		if err := auth.CheckDoor(ctx, false); err != nil {
+			return err
+		}
+		return rn.Devices.BuzzDoor()
+	}
+	return fmt.Errorf("unknown step type %q", st.Type)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@fortnightly",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1- * * * *",
+		"x * * * *",
+		"* * * smarch *",
+		"* * * * funday",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	cet := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// at builds an instant from an explicit offset, to tell repeated hours apart.
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04 -0700", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.In(berlin)
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  []time.Time // successive runs; empty if it never matches
+	}{
+		{"every 20 minutes", "*/20 * * * *", cet("2024-05-06 10:05"),
+			[]time.Time{cet("2024-05-06 10:20"), cet("2024-05-06 10:40"), cet("2024-05-06 11:00")}},
+		{"stepped range", "0 9-17/4 * * *", cet("2024-05-06 10:00"),
+			[]time.Time{cet("2024-05-06 13:00"), cet("2024-05-06 17:00"), cet("2024-05-07 09:00")}},
+		{"from a match is after it", "30 8 * * *", cet("2024-05-06 08:30"),
+			[]time.Time{cet("2024-05-07 08:30")}},
+		{"either day field", "0 0 13 * fri", cet("2024-09-01 12:00"),
+			[]time.Time{cet("2024-09-06 00:00"), cet("2024-09-13 00:00"), cet("2024-09-20 00:00"), cet("2024-09-27 00:00"), cet("2024-10-04 00:00"), cet("2024-10-11 00:00"), cet("2024-10-13 00:00")}},
+		{"day of month only", "0 0 13 * *", cet("2024-09-01 12:00"),
+			[]time.Time{cet("2024-09-13 00:00"), cet("2024-10-13 00:00")}},
+		{"day of week only", "0 0 * * fri", cet("2024-09-01 12:00"),
+			[]time.Time{cet("2024-09-06 00:00"), cet("2024-09-13 00:00")}},
+		{"sunday as 7", "0 12 * * 7", cet("2024-09-02 12:00"),
+			[]time.Time{cet("2024-09-08 12:00")}},
+		{"weekday names and ranges", "0 7 * * mon-fri", cet("2024-09-06 08:00"),
+			[]time.Time{cet("2024-09-09 07:00"), cet("2024-09-10 07:00")}},
+		{"leap day", "0 0 29 feb *", cet("2025-01-01 00:00"),
+			[]time.Time{cet("2028-02-29 00:00")}},
+		{"never", "0 0 31 2 *", cet("2024-01-01 00:00"), nil},
+		{"weekly shorthand", "@weekly", cet("2024-09-02 12:00"),
+			[]time.Time{cet("2024-09-08 00:00")}},
+
+		// Clocks went forward at 02:00 on 2024-03-31; 02:30 didn't happen.
+		{"skipped hour", "30 2 * * *", cet("2024-03-30 12:00"),
+			[]time.Time{cet("2024-04-01 02:30")}},
+		{"hourly over the gap", "0 * * * *", at("2024-03-31 01:30 +0100"),
+			[]time.Time{at("2024-03-31 03:00 +0200"), at("2024-03-31 04:00 +0200")}},
+		// Clocks went back at 03:00 on 2024-10-27; 02:00-02:59 happened twice.
+		{"repeated hour", "30 2 * * *", cet("2024-10-26 12:00"),
+			[]time.Time{at("2024-10-27 02:30 +0200"), at("2024-10-28 02:30 +0100")}},
+		{"repeated hour from midnight", "30 2 * * *", at("2024-10-27 00:00 +0200"),
+			[]time.Time{at("2024-10-27 02:30 +0200")}},
+		{"hourly over the repeat", "0 * * * *", at("2024-10-27 01:30 +0200"),
+			[]time.Time{at("2024-10-27 02:00 +0200"), at("2024-10-27 03:00 +0100")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := tt.after
+			if len(tt.want) == 0 {
+				if got := c.Next(from); !got.IsZero() {
+					t.Fatalf("Next = %v, want none", got)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				got := c.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("Next(%v) = %v, want %v", from, got, want)
+				}
+				from = got
+			}
+		})
+	}
+}
+
+func TestCronNextHalfHourZone(t *testing.T) {
+	kolkata := mustLoad(t, "Asia/Kolkata")
+	c, err := ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.Next(time.Date(2024, 5, 6, 7, 10, 0, 0, kolkata))
+	if want := time.Date(2024, 5, 6, 9, 0, 0, 0, kolkata); !got.Equal(want) {
+		t.Fatalf("Next = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+// Package schedule runs stored schedules: cron expressions or sun events in
+// a time zone that fire a stored target.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+)
+
+const (
+	// MissedAfter is how late a run may start before it counts as missed,
+	// e.g. because the server was down or the host was asleep.
+	MissedAfter = time.Minute
+
+	// maxWait is the longest the scheduler sleeps, so clock jumps are
+	// noticed.
+	maxWait = time.Hour
+
+	// maxMissed bounds how many missed runs are counted.
+	maxMissed = 10000
+)
+
+// Spec is when a schedule fires: its cron expression or sun event, in its
+// time zone.
+type Spec struct {
+	trigger interface{ Next(time.Time) time.Time }
+	loc     *time.Location
+}
+
+// ParseSpec parses a schedule's cron expression, or a sun event like
+// "@sunset-15m", and IANA time zone; an empty zone is the server's.
+func ParseSpec(cron, tz string) (*Spec, error) {
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", tz)
+		}
+	}
+	if sun, ok, err := parseSun(cron); ok {
+		if err != nil {
+			return nil, err
+		}
+		return &Spec{trigger: sun, loc: loc}, nil
+	}
+	c, err := ParseCron(cron)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{trigger: c, loc: loc}, nil
+}
+
+// Next is the first run after t, or nil if there's none.
+func (s *Spec) Next(t time.Time) *time.Time {
+	n := s.trigger.Next(t.In(s.loc))
+	if n.IsZero() {
+		return nil
+	}
+	return &n
+}
+
+// Upcoming lists up to n runs after t, in the schedule's time zone.
+func (s *Spec) Upcoming(t time.Time, n int) []time.Time {
+	out := []time.Time{}
+	for len(out) < n {
+		next := s.Next(t)
+		if next == nil {
+			break
+		}
+		out = append(out, *next)
+		t = *next
+	}
+	return out
+}
+
+// Scheduler fires due schedules. Run carries out a target; it should go
+// through the same command path as the API.
+type Scheduler struct {
+	Run  func(ctx context.Context, o device.Origin, t db.Target) error
+	wake chan struct{}
+}
+
+func New(run func(ctx context.Context, o device.Origin, t db.Target) error) *Scheduler {
+	return &Scheduler{Run: run, wake: make(chan struct{}, 1)}
+}
+
+// Reload makes the scheduler pick up added, changed or deleted schedules.
+func (s *Scheduler) Reload() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the scheduler until ctx ends. Runs that fell due while the
+// server was down are handled first, per each schedule's OnMissed.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		for {
+			t := time.NewTimer(s.tick(ctx, time.Now()))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-s.wake:
+			case <-t.C:
+			}
+			t.Stop()
+		}
+	}()
+}
+
+// tick fires every enabled schedule that's due and returns how long to sleep
+// until the next one.
+func (s *Scheduler) tick(ctx context.Context, now time.Time) time.Duration {
+	list, err := db.ListSchedules(ctx)
+	if err != nil {
+		slog.Error("failed to load schedules", "err", err)
+		return time.Minute
+	}
+	wait := maxWait
+	for i := range list {
+		sc := &list[i]
+		if !sc.Enabled {
+			continue
+		}
+		spec, err := ParseSpec(sc.Cron, sc.TimeZone)
+		if err != nil {
+			slog.Error("invalid schedule", "schedule", sc.Name, "err", err)
+			continue
+		}
+		switch {
+		case sc.NextRunAt == nil:
+			sc.NextRunAt = spec.Next(now)
+			if err := db.SetScheduleNextRun(ctx, sc.ID, sc.NextRunAt); err != nil {
+				slog.Error("failed to store next run", "schedule", sc.Name, "err", err)
+			}
+		case !sc.NextRunAt.After(now):
+			s.fire(ctx, sc, spec, now)
+		}
+		if sc.NextRunAt != nil {
+			wait = min(wait, sc.NextRunAt.Sub(now))
+		}
+	}
+	return wait
+}
+
+// fire runs a due schedule, or records it as missed when it's too late, and
+// moves it on to its next run.
+func (s *Scheduler) fire(ctx context.Context, sc *db.Schedule, spec *Spec, now time.Time) {
+	due := *sc.NextRunAt
+	sc.NextRunAt = spec.Next(now)
+	if err := db.SetScheduleNextRun(ctx, sc.ID, sc.NextRunAt); err != nil {
+		// Running now could repeat forever; try again on the next tick.
+		slog.Error("failed to store next run", "schedule", sc.Name, "err", err)
+		return
+	}
+
+	if now.Sub(due) > MissedAfter {
+		n, last := missedRuns(spec, due, now)
+		window := time.Duration(sc.MissedWindowS) * time.Second
+		if sc.OnMissed != db.MissedRunOnce || (window > 0 && now.Sub(last) > window) {
+			slog.Warn("schedule missed runs", "schedule", sc.Name, "missed", n, "since", due)
+			msg := fmt.Sprintf("missed %d run(s) since %s", n, due.In(spec.loc).Format(time.RFC3339))
+			if err := db.RecordScheduleRun(ctx, sc.ID, last, "missed", msg); err != nil {
+				slog.Error("failed to record schedule run", "schedule", sc.Name, "err", err)
+			}
+			return
+		}
+		slog.Info("running missed schedule once", "schedule", sc.Name, "missed", n, "since", due)
+	}
+
+	go func() {
+		o := device.Origin{Command: "schedule:" + sc.Name, Actor: "schedule:" + strconv.FormatInt(sc.ID, 10)}
+		err := s.Run(ctx, o, sc.Target)
+		result, msg := "ok", ""
+		if err != nil {
+			result, msg = "failed", err.Error()
+			slog.Warn("schedule failed", "schedule", sc.Name, "err", err)
+		} else {
+			slog.Info("schedule ran", "schedule", sc.Name, "target", sc.Target.Type)
+		}
+		if err := db.RecordScheduleRun(context.WithoutCancel(ctx), sc.ID, now, result, msg); err != nil {
+			slog.Error("failed to record schedule run", "schedule", sc.Name, "err", err)
+		}
+	}()
+}
+
+// missedRuns counts the runs from due up to now and returns the last of them.
+func missedRuns(spec *Spec, due, now time.Time) (n int, last time.Time) {
+	for t := &due; t != nil && !t.After(now) && n < maxMissed; t = spec.Next(*t) {
+		n, last = n+1, *t
+	}
+	return n, last
+}
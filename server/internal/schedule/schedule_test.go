@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+)
+
+// openDB connects to a fresh database next to the test binary.
+func openDB(t *testing.T) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := filepath.Glob(filepath.Join(filepath.Dir(exe), db.DEFAULT_DB_NAME+"*"))
+	for _, f := range old {
+		_ = os.Remove(f)
+	}
+	db.Connect(context.Background())
+	t.Cleanup(func() { _ = db.DB.Close() })
+}
+
+func TestMissedRuns(t *testing.T) {
+	spec, err := ParseSpec("*/10 * * * *", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC)
+	n, last := missedRuns(spec, due, due.Add(65*time.Minute))
+	if want := due.Add(time.Hour); n != 7 || !last.Equal(want) {
+		t.Fatalf("missedRuns = %d, %v; want 7, %v", n, last, want)
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 5, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		onMissed   string
+		windowS    int64
+		due        time.Time
+		wantRun    bool
+		wantResult string
+	}{
+		{"on time", db.MissedSkip, 0, now.Add(-30 * time.Second), true, "ok"},
+		{"skip", db.MissedSkip, 0, now.Add(-65 * time.Minute), false, "missed"},
+		{"run once", db.MissedRunOnce, 0, now.Add(-65 * time.Minute), true, "ok"},
+		{"run once within window", db.MissedRunOnce, 600, now.Add(-65 * time.Minute), true, "ok"},
+		{"run once outside window", db.MissedRunOnce, 60, now.Add(-65 * time.Minute), false, "missed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openDB(t)
+			ctx := context.Background()
+			due := tt.due
+			sc := &db.Schedule{
+				Name:          "lights",
+				Cron:          "*/10 * * * *",
+				TimeZone:      "UTC",
+				Target:        db.Target{Type: db.TargetRelays, Action: "all_off"},
+				Enabled:       true,
+				OnMissed:      tt.onMissed,
+				MissedWindowS: tt.windowS,
+				NextRunAt:     &due,
+			}
+			if err := db.CreateSchedule(ctx, sc); err != nil {
+				t.Fatal(err)
+			}
+
+			ran := make(chan db.Target, 2)
+			s := New(func(ctx context.Context, o device.Origin, target db.Target) error {
+				ran <- target
+				return nil
+			})
+			s.tick(ctx, now)
+
+			// Runs happen in the background.
+			if tt.wantRun {
+				select {
+				case <-ran:
+				case <-time.After(5 * time.Second):
+					t.Fatal("schedule did not run")
+				}
+			}
+			select {
+			case <-ran:
+				t.Fatal("schedule ran one time too many")
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			// A run records its result after the target is done.
+			var got *db.Schedule
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+				var err error
+				if got, err = db.GetSchedule(ctx, sc.ID); err != nil {
+					t.Fatal(err)
+				}
+				if got.LastResult != "" || time.Now().After(deadline) {
+					break
+				}
+			}
+			if got.LastResult != tt.wantResult {
+				t.Errorf("last result = %q (%q), want %q", got.LastResult, got.LastError, tt.wantResult)
+			}
+			if want := time.Date(2024, 5, 6, 12, 10, 0, 0, time.UTC); got.NextRunAt == nil || !got.NextRunAt.Equal(want) {
+				t.Errorf("next run = %v, want %v", got.NextRunAt, want)
+			}
+		})
+	}
+}
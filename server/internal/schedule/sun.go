@@ -0,0 +1,183 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sun events a schedule can follow. Dawn and dusk are civil twilight, when
+// the sun is 6° below the horizon.
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+	Dawn    = "dawn"
+	Dusk    = "dusk"
+)
+
+// zenith is how far from straight up the sun's centre is at each event;
+// sunrise and sunset allow for refraction and the sun's radius.
+var zenith = map[string]float64{
+	Sunrise: 90.833,
+	Sunset:  90.833,
+	Dawn:    96,
+	Dusk:    96,
+}
+
+// Coordinates is where the sun is computed for, in degrees; north and east
+// are positive.
+type Coordinates struct {
+	Lat, Lon float64
+}
+
+var site *Coordinates
+
+// SetLocation sets where sun-relative schedules are computed for. Until it's
+// called they're rejected.
+func SetLocation(c Coordinates) {
+	site = &c
+}
+
+// Location returns what SetLocation set, if anything.
+func Location() (Coordinates, bool) {
+	if site == nil {
+		return Coordinates{}, false
+	}
+	return *site, true
+}
+
+// ParseCoordinates parses "lat,lon", e.g. "52.52,13.405".
+func ParseCoordinates(s string) (Coordinates, error) {
+	a, b, ok := strings.Cut(s, ",")
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if !ok || err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return Coordinates{}, fmt.Errorf("want latitude,longitude in degrees, e.g. 52.52,13.405")
+	}
+	return Coordinates{Lat: lat, Lon: lon}, nil
+}
+
+// sunTrigger fires at a sun event plus an offset every day the event
+// happens. Near the poles there are days without one; they're skipped.
+type sunTrigger struct {
+	event  string
+	offset time.Duration
+	at     Coordinates
+}
+
+// parseSun parses "@sunset", "@sunset-15m", "@sunrise +1h30m" and the same
+// for @dawn and @dusk. ok is false if expr isn't a sun expression at all.
+func parseSun(expr string) (t *sunTrigger, ok bool, err error) {
+	rest, found := strings.CutPrefix(strings.ToLower(strings.TrimSpace(expr)), "@")
+	if !found {
+		return nil, false, nil
+	}
+	var event string
+	for e := range zenith {
+		if strings.HasPrefix(rest, e) {
+			event = e
+		}
+	}
+	if event == "" {
+		return nil, false, nil
+	}
+	t = &sunTrigger{event: event}
+	if off := strings.ReplaceAll(strings.TrimPrefix(rest, event), " ", ""); off != "" {
+		if off[0] != '+' && off[0] != '-' {
+			return nil, true, fmt.Errorf("%q: want an offset like -15m or +1h", expr)
+		}
+		if t.offset, err = time.ParseDuration(off); err != nil {
+			return nil, true, fmt.Errorf("%q: want an offset like -15m or +1h", expr)
+		}
+		if t.offset <= -12*time.Hour || t.offset >= 12*time.Hour {
+			return nil, true, fmt.Errorf("%q: offset must be under 12h", expr)
+		}
+	}
+	if site == nil {
+		return nil, true, errors.New("sun times need the server's location (--location=lat,lon)")
+	}
+	t.at = *site
+	return t, true, nil
+}
+
+// maxSunDays bounds the search for a day with the event, past a polar night.
+const maxSunDays = 370
+
+func (s *sunTrigger) Next(t time.Time) time.Time {
+	loc := t.Location()
+	y, m, d := t.Date()
+	// start a day early: an offset can push yesterday's event past t
+	for i := -1; i < maxSunDays; i++ {
+		ev, ok := SunEvent(s.event, time.Date(y, m, d+i, 12, 0, 0, 0, loc), s.at)
+		if !ok {
+			continue
+		}
+		if at := ev.Add(s.offset).Truncate(time.Minute); at.After(t) {
+			return at.In(loc)
+		}
+	}
+	return time.Time{}
+}
+
+// SunEvent is when event happens on day's date (in day's location) at c,
+// using the sunrise equation from the Almanac for Computers, good to about a
+// minute. ok is false if the sun doesn't reach the event's height that day.
+func SunEvent(event string, day time.Time, c Coordinates) (at time.Time, ok bool) {
+	z, known := zenith[event]
+	if !known {
+		return time.Time{}, false
+	}
+	rising := event == Sunrise || event == Dawn
+	rad := math.Pi / 180
+
+	lngHour := c.Lon / 15
+	approx := 18.0
+	if rising {
+		approx = 6
+	}
+	t := float64(day.YearDay()) + (approx-lngHour)/24
+
+	// the sun's mean anomaly, true longitude and right ascension
+	mean := 0.9856*t - 3.289
+	l := norm(mean+1.916*math.Sin(mean*rad)+0.020*math.Sin(2*mean*rad)+282.634, 360)
+	ra := norm(math.Atan(0.91764*math.Tan(l*rad))/rad, 360)
+	ra += math.Floor(l/90)*90 - math.Floor(ra/90)*90
+	ra /= 15
+
+	sinDec := 0.39782 * math.Sin(l*rad)
+	cosDec := math.Cos(math.Asin(sinDec))
+	cosH := (math.Cos(z*rad) - sinDec*math.Sin(c.Lat*rad)) / (cosDec * math.Cos(c.Lat*rad))
+	if cosH > 1 || cosH < -1 {
+		return time.Time{}, false
+	}
+	h := math.Acos(cosH) / rad
+	if rising {
+		h = 360 - h
+	}
+	local := h/15 + ra - 0.06571*t - 6.622
+	ut := norm(local-lngHour, 24)
+
+	// ut is the hour of the day in UTC; pick the UTC day that puts the event
+	// on day's local date.
+	y, m, d := day.Date()
+	base := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	at = base.Add(time.Duration(ut * float64(time.Hour))).In(day.Location())
+	for _, shift := range []int{0, -1, 1} {
+		a := at.AddDate(0, 0, shift)
+		if ay, am, ad := a.Date(); ay == y && am == m && ad == d {
+			return a, true
+		}
+	}
+	return at, true
+}
+
+func norm(v, mod float64) float64 {
+	v = math.Mod(v, mod)
+	if v < 0 {
+		v += mod
+	}
+	return v
+}
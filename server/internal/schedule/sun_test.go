@@ -0,0 +1,131 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	berlin  = Coordinates{Lat: 52.52, Lon: 13.405}
+	tromsoe = Coordinates{Lat: 69.65, Lon: 18.96}
+)
+
+// withLocation sets the server's location for one test.
+func withLocation(t *testing.T, c Coordinates) {
+	t.Helper()
+	old := site
+	SetLocation(c)
+	t.Cleanup(func() { site = old })
+}
+
+func TestSunEvent(t *testing.T) {
+	berlinTZ := mustLoad(t, "Europe/Berlin")
+	osloTZ := mustLoad(t, "Europe/Oslo")
+	tests := []struct {
+		event string
+		day   time.Time
+		at    Coordinates
+		want  string // local "15:04"; empty if the event doesn't happen
+	}{
+		{Sunrise, time.Date(2024, 6, 21, 12, 0, 0, 0, berlinTZ), berlin, "04:43"},
+		{Sunset, time.Date(2024, 6, 21, 12, 0, 0, 0, berlinTZ), berlin, "21:33"},
+		{Dawn, time.Date(2024, 6, 21, 12, 0, 0, 0, berlinTZ), berlin, "03:52"},
+		{Dusk, time.Date(2024, 6, 21, 12, 0, 0, 0, berlinTZ), berlin, "22:24"},
+		{Sunrise, time.Date(2024, 12, 21, 12, 0, 0, 0, berlinTZ), berlin, "08:15"},
+		{Sunset, time.Date(2024, 12, 21, 12, 0, 0, 0, berlinTZ), berlin, "15:54"},
+
+		// midnight sun and polar night
+		{Sunrise, time.Date(2024, 6, 21, 12, 0, 0, 0, osloTZ), tromsoe, ""},
+		{Sunset, time.Date(2024, 6, 21, 12, 0, 0, 0, osloTZ), tromsoe, ""},
+		{Sunrise, time.Date(2024, 12, 21, 12, 0, 0, 0, osloTZ), tromsoe, ""},
+		{Sunset, time.Date(2024, 12, 21, 12, 0, 0, 0, osloTZ), tromsoe, ""},
+		// civil twilight still happens at noon in the polar night
+		{Dawn, time.Date(2024, 12, 21, 12, 0, 0, 0, osloTZ), tromsoe, "09:31"},
+	}
+	for _, tt := range tests {
+		got, ok := SunEvent(tt.event, tt.day, tt.at)
+		name := tt.event + " " + tt.day.Format("2006-01-02")
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: got %v, want none", name, got)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: got none, want %s", name, tt.want)
+			continue
+		}
+		want, err := time.ParseInLocation("2006-01-02 15:04", tt.day.Format("2006-01-02 ")+tt.want, tt.day.Location())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The almanac formula is good to about a minute.
+		if d := got.Sub(want); d < -2*time.Minute || d > 2*time.Minute {
+			t.Errorf("%s: got %s, want %s", name, got.Format("15:04:05"), tt.want)
+		}
+	}
+}
+
+func TestSunTriggerNext(t *testing.T) {
+	berlinTZ := mustLoad(t, "Europe/Berlin")
+	osloTZ := mustLoad(t, "Europe/Oslo")
+	tests := []struct {
+		name     string
+		at       Coordinates
+		expr     string
+		after    time.Time
+		from, to time.Time // the run must fall in [from, to]
+	}{
+		{"sunset", berlin, "@sunset", time.Date(2024, 6, 21, 12, 0, 0, 0, berlinTZ),
+			time.Date(2024, 6, 21, 21, 31, 0, 0, berlinTZ), time.Date(2024, 6, 21, 21, 35, 0, 0, berlinTZ)},
+		{"sunset -15m", berlin, "@sunset-15m", time.Date(2024, 6, 21, 12, 0, 0, 0, berlinTZ),
+			time.Date(2024, 6, 21, 21, 16, 0, 0, berlinTZ), time.Date(2024, 6, 21, 21, 20, 0, 0, berlinTZ)},
+		{"after today's run", berlin, "@sunset-15m", time.Date(2024, 6, 21, 21, 30, 0, 0, berlinTZ),
+			time.Date(2024, 6, 22, 21, 16, 0, 0, berlinTZ), time.Date(2024, 6, 22, 21, 20, 0, 0, berlinTZ)},
+		// Tomorrow's sunrise minus 5h is before midnight today.
+		{"offset back over midnight", berlin, "@sunrise -5h", time.Date(2024, 6, 20, 12, 0, 0, 0, berlinTZ),
+			time.Date(2024, 6, 20, 23, 41, 0, 0, berlinTZ), time.Date(2024, 6, 20, 23, 45, 0, 0, berlinTZ)},
+		// Yesterday's sunset plus 3h is after midnight today.
+		{"offset on over midnight", berlin, "@sunset+3h", time.Date(2024, 6, 22, 0, 10, 0, 0, berlinTZ),
+			time.Date(2024, 6, 22, 0, 31, 0, 0, berlinTZ), time.Date(2024, 6, 22, 0, 35, 0, 0, berlinTZ)},
+		// The first sunset after the midnight sun, and the first sunrise
+		// after the polar night.
+		{"midnight sun", tromsoe, "@sunset", time.Date(2024, 6, 21, 12, 0, 0, 0, osloTZ),
+			time.Date(2024, 7, 22, 0, 0, 0, 0, osloTZ), time.Date(2024, 7, 27, 0, 0, 0, 0, osloTZ)},
+		{"polar night", tromsoe, "@sunrise", time.Date(2024, 12, 1, 12, 0, 0, 0, osloTZ),
+			time.Date(2025, 1, 14, 0, 0, 0, 0, osloTZ), time.Date(2025, 1, 18, 0, 0, 0, 0, osloTZ)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withLocation(t, tt.at)
+			spec, err := ParseSpec(tt.expr, tt.after.Location().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := spec.Next(tt.after)
+			if got == nil {
+				t.Fatal("Next = none")
+			}
+			if got.Before(tt.from) || got.After(tt.to) {
+				t.Fatalf("Next = %v, want between %v and %v", got, tt.from, tt.to)
+			}
+			if got.Second() != 0 {
+				t.Errorf("Next = %v, want a whole minute", got)
+			}
+		})
+	}
+}
+
+func TestParseSunErrors(t *testing.T) {
+	withLocation(t, berlin)
+	site = nil
+	if _, err := ParseSpec("@sunset", ""); err == nil {
+		t.Error("sun schedule accepted without a location")
+	}
+	SetLocation(berlin)
+	for _, expr := range []string{"@sunset15m", "@sunset+15x", "@sunrise-12h", "@dusk+13h"} {
+		if _, err := ParseSpec(expr, ""); err == nil {
+			t.Errorf("ParseSpec(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package timer ends relay timers: when one runs out its relay is switched
+// to the timer's end state. Timers are kept in SQLite, so ones that ran out
+// while the server was down end as soon as it's back.
+package timer
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"relaypanel/internal/db"
+	"relaypanel/internal/device"
+	"relaypanel/internal/events"
+)
+
+// maxWait is the longest the timer loop sleeps, so clock jumps are noticed.
+const maxWait = time.Hour
+
+// Timers fires relay timers. Run carries out the end action; it should go
+// through the same command path as the API. Ended timers are published on
+// Events, if set.
+type Timers struct {
+	Run    func(ctx context.Context, o device.Origin, t db.Target) error
+	Events *events.Hub
+	wake   chan struct{}
+}
+
+func New(run func(ctx context.Context, o device.Origin, t db.Target) error, hub *events.Hub) *Timers {
+	return &Timers{Run: run, Events: hub, wake: make(chan struct{}, 1)}
+}
+
+// Reload makes the loop pick up added or cancelled timers.
+func (t *Timers) Reload() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start ends timers as they run out until ctx ends.
+func (t *Timers) Start(ctx context.Context) {
+	go func() {
+		for {
+			tm := time.NewTimer(t.tick(ctx, time.Now()))
+			select {
+			case <-ctx.Done():
+				tm.Stop()
+				return
+			case <-t.wake:
+			case <-tm.C:
+			}
+			tm.Stop()
+		}
+	}()
+}
+
+// tick ends every timer that's run out and returns how long to sleep until
+// the next one does.
+func (t *Timers) tick(ctx context.Context, now time.Time) time.Duration {
+	list, err := db.ListActiveRelayTimers(ctx)
+	if err != nil {
+		slog.Error("failed to load relay timers", "err", err)
+		return time.Minute
+	}
+	wait := maxWait
+	for _, rt := range list {
+		if rt.EndsAt.After(now) {
+			wait = min(wait, rt.EndsAt.Sub(now))
+			continue
+		}
+		if late := now.Sub(rt.EndsAt); late > time.Minute {
+			slog.Warn("relay timer ran out while the server was down", "timer", rt.ID, "relay", rt.Relay, "late", late.Round(time.Second))
+		}
+		t.end(ctx, rt)
+	}
+	return wait
+}
+
+func (t *Timers) end(ctx context.Context, rt db.RelayTimer) {
+	ok, err := db.FinishRelayTimer(ctx, rt.ID, "ok", "")
+	if err != nil {
+		slog.Error("failed to end relay timer", "timer", rt.ID, "err", err)
+		return
+	}
+	if !ok {
+		return // cancelled meanwhile
+	}
+	if t.Events != nil {
+		t.Events.Publish(events.TypeTimer, events.TimerChange{Relay: rt.Relay})
+	}
+	id := strconv.Itoa(rt.Relay)
+	o := device.Origin{Command: "relay.timer", Actor: "timer:" + strconv.FormatInt(rt.ID, 10)}
+	err = t.Run(ctx, o, db.Target{Type: db.TargetRelays, States: db.RelayStates{id: rt.EndState}})
+	if err != nil {
+		slog.Warn("relay timer end action failed", "timer", rt.ID, "relay", rt.Relay, "err", err)
+		if err := db.SetRelayTimerResult(ctx, rt.ID, "failed", err.Error()); err != nil {
+			slog.Error("failed to record relay timer result", "timer", rt.ID, "err", err)
+		}
+		return
+	}
+	slog.Info("relay timer ended", "timer", rt.ID, "relay", rt.Relay, "state", rt.EndState)
+}